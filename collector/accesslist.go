@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	accessListEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "access_list_entries"),
+		"Number of entries in the blacklist or whitelist of a virtual service.",
+		[]string{"vs", "list"},
+		nil,
+	)
+	accessListEntryInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "access_list_entry_info"),
+		"Entry in the blacklist or whitelist of a virtual service.",
+		[]string{"vs", "list", "addr"},
+		nil,
+	)
+)
+
+// AccessListCollector exports the blacklist and whitelist of every exported
+// virtual service, including empty ones. Per-entry series are only exported
+// if entries is set.
+type AccessListCollector struct {
+	comm    lb.Backend
+	lists   lb.AccessLister
+	entries bool
+}
+
+func NewAccessListCollector(comm lb.Backend, lists lb.AccessLister, entries bool) *AccessListCollector {
+	return &AccessListCollector{
+		comm:    comm,
+		lists:   lists,
+		entries: entries,
+	}
+}
+
+func (c *AccessListCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- accessListEntriesDesc
+	if c.entries {
+		ch <- accessListEntryInfoDesc
+	}
+}
+
+func (c *AccessListCollector) Update(ch chan<- prometheus.Metric) error {
+	_, err := c.UpdateLimited(ch, 0)
+	return err
+}
+
+// UpdateLimited keeps the services carrying the most traffic, with the
+// series of both their lists, when they exceed maxSeries.
+func (c *AccessListCollector) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
+	services, err := c.comm.ListVirtualServices()
+	if err != nil || services == nil {
+		return 0, err
+	}
+	var lists []map[string][]string // indexed like accessLists, keyed by service
+	for _, list := range accessLists {
+		entries, err := list.fetch(c.lists)
+		if err != nil {
+			return 0, err
+		}
+		byService := make(map[string][]string)
+		for _, e := range entries {
+			proto := int64(e.Proto)
+			key := GetServerIdentifier(&e.VIP, &e.Port, &proto)
+			byService[key] = append(byService[key], e.Addr)
+		}
+		lists = append(lists, byService)
+	}
+	var entries []rankedEntry
+	for _, vss := range services.Items {
+		key := GetServiceIdentifier(&vss)
+		if _, exists := connInfo[key]; !exists {
+			continue
+		}
+		e := rankedEntry{weight: statsTraffic(vss.Stats)}
+		for i, list := range accessLists {
+			addrs := lists[i][key]
+			e.metrics = append(e.metrics, prometheus.MustNewConstMetric(accessListEntriesDesc, prometheus.GaugeValue, float64(len(addrs)), key, list.name))
+			if !c.entries {
+				continue
+			}
+			for _, addr := range addrs {
+				e.metrics = append(e.metrics, prometheus.MustNewConstMetric(accessListEntryInfoDesc, prometheus.GaugeValue, 1, key, list.name, addr))
+			}
+		}
+		entries = append(entries, e)
+	}
+	return emitRanked(ch, entries, maxSeries), nil
+}
+
+// accessLists are the lists exported by AccessListCollector.
+var accessLists = []struct {
+	name  string
+	fetch func(lb.AccessLister) ([]lb.AccessListEntry, error)
+}{
+	{"blklst", lb.AccessLister.ListBlacklist},
+	{"whtlst", lb.AccessLister.ListWhitelist},
+}
@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"dpvs_exporter/lb"
+	"dpvs_exporter/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeAccessLists struct {
+	black, white []lb.AccessListEntry
+	err          error
+}
+
+func (f *fakeAccessLists) ListBlacklist() ([]lb.AccessListEntry, error) { return f.black, f.err }
+func (f *fakeAccessLists) ListWhitelist() ([]lb.AccessListEntry, error) { return f.white, nil }
+
+func TestAccessListCollector(t *testing.T) {
+	entry := func(vip, addr string) lb.AccessListEntry {
+		return lb.AccessListEntry{VIP: vip, Port: 80, Proto: utils.IPProtoTCP, Addr: addr}
+	}
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80),
+		testService("2001:db8::1", 80),
+		testService("10.0.0.3", 80),
+	}}}
+	InitConnStatsController(backend.services.Items[:2], nil) // 10.0.0.3 is not exported
+	lists := &fakeAccessLists{
+		black: []lb.AccessListEntry{
+			entry("10.0.0.1", "1.1.1.1"),
+			entry("10.0.0.1", "2.2.2.2"),
+			entry("2001:DB8::1", "2001:db8:1::1"),
+			entry("10.0.0.3", "3.3.3.3"),
+		},
+		white: []lb.AccessListEntry{entry("10.0.0.1", "192.168.0.1")},
+	}
+
+	c := NewAccessListCollector(backend, lists, false)
+	want := map[string]float64{
+		"list=blklst,vs=10.0.0.1:80:TCP":      2,
+		"list=whtlst,vs=10.0.0.1:80:TCP":      1,
+		"list=blklst,vs=[2001:db8::1]:80:TCP": 1,
+		"list=whtlst,vs=[2001:db8::1]:80:TCP": 0,
+	}
+	if got := gather(t, c, accessListEntriesDesc); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := gather(t, c, accessListEntryInfoDesc); len(got) != 0 {
+		t.Errorf("got entries %v without asking for them", got)
+	}
+
+	c = NewAccessListCollector(backend, lists, true)
+	want = map[string]float64{
+		"addr=1.1.1.1,list=blklst,vs=10.0.0.1:80:TCP":            1,
+		"addr=2.2.2.2,list=blklst,vs=10.0.0.1:80:TCP":            1,
+		"addr=192.168.0.1,list=whtlst,vs=10.0.0.1:80:TCP":        1,
+		"addr=2001:db8:1::1,list=blklst,vs=[2001:db8::1]:80:TCP": 1,
+	}
+	if got := gather(t, c, accessListEntryInfoDesc); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+
+	// The busiest service is kept with both its lists and all its entries.
+	busy := int64(100)
+	backend.services.Items[1].Stats.InBytes = &busy
+	ch := make(chan prometheus.Metric, 16)
+	dropped, err := c.UpdateLimited(ch, 5)
+	close(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ch) != 3 || dropped != 5 {
+		t.Errorf("got %d series, %d dropped; want 3, 5", len(ch), dropped)
+	}
+
+	lists.err = errors.New("dpip: exit status 1")
+	if err := c.Update(make(chan prometheus.Metric, 16)); err == nil {
+		t.Error("Update succeeded despite the blacklist failing")
+	}
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"fmt"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	aclEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "acl_entries"),
+		"Number of entries in the allow or deny list of a virtual service.",
+		[]string{"vs", "list"},
+		nil,
+	)
+	aclEntryInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "acl_entry_info"),
+		"Entry in the allow or deny list of a virtual service.",
+		[]string{"vs", "list", "addr"},
+		nil,
+	)
+)
+
+// AclCollector exports the allow and deny lists of every exported virtual
+// service. Per-entry series are only exported if entries is set.
+type AclCollector struct {
+	services    lb.Backend
+	comm        *lb.DpvsAgentComm
+	entries     bool
+	concurrency int
+}
+
+// NewAclCollector returns an AclCollector listing services from services and
+// querying the lists of up to concurrency of them at once, DefaultConcurrency
+// if zero.
+func NewAclCollector(services lb.Backend, comm *lb.DpvsAgentComm, entries bool, concurrency int) *AclCollector {
+	return &AclCollector{
+		services:    services,
+		comm:        comm,
+		entries:     entries,
+		concurrency: concurrency,
+	}
+}
+
+func (c *AclCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- aclEntriesDesc
+	if c.entries {
+		ch <- aclEntryInfoDesc
+	}
+}
+
+// aclLists are the lists of a service, each fetched by its own request.
+var aclLists = []struct {
+	name  string
+	fetch func(*lb.DpvsAgentComm, string) ([]lb.CertAuthSpec, error)
+}{
+	{"allow", (*lb.DpvsAgentComm).ListAllowList},
+	{"deny", (*lb.DpvsAgentComm).ListDenyList},
+}
+
+func (c *AclCollector) Update(ch chan<- prometheus.Metric) error {
+	_, err := c.UpdateLimited(ch, 0)
+	return err
+}
+
+// UpdateLimited keeps the services carrying the most traffic, with the
+// series of both their lists, when they exceed maxSeries.
+func (c *AclCollector) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
+	services, err := c.services.ListVirtualServices()
+	if err != nil || services == nil {
+		return 0, err
+	}
+	var (
+		keys, vipPorts []string
+		entries        []rankedEntry
+	)
+	for i := range services.Items {
+		key := GetServiceIdentifier(&services.Items[i])
+		vipPort := lb.VipPort(&services.Items[i])
+		if _, exists := connInfo[key]; exists && vipPort != "" {
+			keys = append(keys, key)
+			vipPorts = append(vipPorts, vipPort)
+			entries = append(entries, rankedEntry{weight: statsTraffic(services.Items[i].Stats)})
+		}
+	}
+	// Every list of every service is a request of its own.
+	acls := make([][]lb.CertAuthSpec, len(keys)*len(aclLists))
+	err = fanout(len(acls), c.concurrency, func(i int) error {
+		svc, list := i/len(aclLists), aclLists[i%len(aclLists)]
+		var err error
+		if acls[i], err = list.fetch(c.comm, vipPorts[svc]); err != nil {
+			return fmt.Errorf("%s: %w", keys[svc], err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, acl := range acls {
+		svc, list := i/len(aclLists), aclLists[i%len(aclLists)].name
+		e := &entries[svc]
+		e.metrics = append(e.metrics, prometheus.MustNewConstMetric(aclEntriesDesc, prometheus.GaugeValue, float64(len(acl)), keys[svc], list))
+		if !c.entries {
+			continue
+		}
+		for _, entry := range acl {
+			e.metrics = append(e.metrics, prometheus.MustNewConstMetric(aclEntryInfoDesc, prometheus.GaugeValue, 1, keys[svc], list, safeDereference(entry.Addr)))
+		}
+	}
+	return emitRanked(ch, entries, maxSeries), nil
+}
@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"testing"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAclCollector(t *testing.T) {
+	str := func(s string) *string { return &s }
+	agent := fakeAgent(t, map[string]interface{}{
+		"/v2/vs/10.0.0.1-80-tcp/allow": lb.AclAddrList{Items: []lb.CertAuthSpec{{Addr: str("10.2.0.1")}, {Addr: str("10.2.0.2")}}},
+		"/v2/vs/10.0.0.1-80-tcp/deny":  lb.AclAddrList{},
+		"/v2/vs/10.0.0.2-80-tcp/allow": lb.AclAddrList{},
+		"/v2/vs/10.0.0.2-80-tcp/deny":  lb.AclAddrList{Items: []lb.CertAuthSpec{{Addr: str("10.3.0.1")}}},
+	})
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80),
+		testService("10.0.0.2", 80),
+	}}}
+	InitConnStatsController(backend.services.Items, nil)
+	c := NewAclCollector(backend, agent, true, 2)
+
+	entries := gather(t, c, aclEntriesDesc)
+	for labels, want := range map[string]float64{
+		"list=allow,vs=10.0.0.1:80:TCP": 2,
+		"list=deny,vs=10.0.0.1:80:TCP":  0,
+		"list=allow,vs=10.0.0.2:80:TCP": 0,
+		"list=deny,vs=10.0.0.2:80:TCP":  1,
+	} {
+		if got, exists := entries[labels]; !exists || got != want {
+			t.Errorf("got %v entries for %s, want %v", got, labels, want)
+		}
+	}
+	if info := gather(t, c, aclEntryInfoDesc); len(info) != 3 || info["addr=10.3.0.1,list=deny,vs=10.0.0.2:80:TCP"] != 1 {
+		t.Errorf("unexpected entry info %v", info)
+	}
+
+	backend.services.Items = append(backend.services.Items, testService("10.0.0.3", 80))
+	InitConnStatsController(backend.services.Items, nil)
+	if err := c.Update(make(chan prometheus.Metric, 64)); err == nil {
+		t.Error("expected an error for a service the agent doesn't know")
+	}
+}
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	agentBuildInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "agent", "build_info"),
+		"A metric with a constant '1' value labeled by the dpvs-agent version.",
+		[]string{"version"},
+		nil,
+	)
+	agentEndpointDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "agent", "endpoint_supported"),
+		"Whether the dpvs-agent serves an endpoint group.",
+		[]string{"endpoint"},
+		nil,
+	)
+)
+
+// collectorEndpoints maps the collectors querying dpvs-agent to the endpoint
+// group they depend on.
+var collectorEndpoints = map[string]string{
+	"conn":      lb.EndpointVS,
+	"bandwidth": lb.EndpointVS,
+	"share":     lb.EndpointVS,
+	"aggregate": lb.EndpointVS,
+	"resets":    lb.EndpointVS,
+	"topology":  lb.EndpointVS,
+	"flap":      lb.EndpointVS,
+	"nic":       lb.EndpointNic,
+	"laddr":     lb.EndpointLaddr,
+	"acl":       lb.EndpointACL,
+	"device":    lb.EndpointDevice,
+	"ipset":     lb.EndpointIpset,
+}
+
+// DefaultProbeInterval is the interval at which dpvs-agent is probed again.
+const DefaultProbeInterval = 5 * time.Minute
+
+// AgentCollector probes dpvs-agent for its version and endpoints, on the
+// first scrape, every interval, and again once the agent is reachable after
+// an agent backed collector failed to connect to it, e.g. because it was
+// restarted or upgraded.
+type AgentCollector struct {
+	comm     *lb.DpvsAgentComm
+	interval time.Duration
+
+	mu     sync.Mutex
+	info   *lb.AgentInfo
+	probed time.Time
+	last   *lb.AgentInfo // last successful probe, kept while re-probing
+}
+
+// NewAgentCollector returns an AgentCollector probing comm every interval,
+// DefaultProbeInterval if zero.
+func NewAgentCollector(comm *lb.DpvsAgentComm, interval time.Duration) *AgentCollector {
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	return &AgentCollector{
+		comm:     comm,
+		interval: interval,
+	}
+}
+
+func (c *AgentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- agentBuildInfoDesc
+	ch <- agentEndpointDesc
+}
+
+func (c *AgentCollector) Update(ch chan<- prometheus.Metric) error {
+	info, err := c.refresh()
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(agentBuildInfoDesc, prometheus.GaugeValue, 1, info.Version)
+	for endpoint, ok := range info.Endpoints {
+		var v float64
+		if ok {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(agentEndpointDesc, prometheus.GaugeValue, v, endpoint)
+	}
+	return nil
+}
+
+// Supported reports whether the agent serves the endpoints collector depends
+// on. Collectors not backed by the agent, and any collector before the agent
+// could be probed, with an endpoint group that failed to be probed, or with
+// another backend (a nil c), are supported.
+func (c *AgentCollector) Supported(collector string) bool {
+	endpoint, exists := collectorEndpoints[collector]
+	if !exists || c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return true
+	}
+	supported, known := c.last.Endpoints[endpoint]
+	return supported || !known
+}
+
+// Invalidate makes the next scrape probe the agent again.
+func (c *AgentCollector) Invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.info = nil
+}
+
+func (c *AgentCollector) refresh() (*lb.AgentInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.info != nil && time.Since(c.probed) < c.interval {
+		return c.info, nil
+	}
+	// Until the agent can be probed, e.g. while it is down, it is probed on
+	// every scrape.
+	c.info = nil
+	info, err := c.comm.Probe()
+	if err != nil {
+		return nil, err
+	}
+	c.probed = time.Now()
+	if c.last == nil || c.last.Version != info.Version {
+		log.Printf("dpvs-agent version %s", info.Version)
+	}
+	for endpoint, ok := range info.Endpoints {
+		if c.last != nil {
+			if prev, known := c.last.Endpoints[endpoint]; known && prev == ok {
+				continue
+			}
+		}
+		if ok {
+			log.Printf("dpvs-agent serves %s endpoints", endpoint)
+		} else {
+			log.Printf("dpvs-agent does not serve %s endpoints, collectors depending on them are disabled", endpoint)
+		}
+	}
+	for endpoint, err := range info.Errors {
+		log.Printf("Failed to probe dpvs-agent %s endpoints, collectors depending on them are kept: %v", endpoint, err)
+	}
+	c.info, c.last = info, info
+	return info, nil
+}
@@ -0,0 +1,166 @@
+package collector
+
+import (
+	"sync"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// aggregateIndicators are the descs of the totals of one kind of aggregate.
+type aggregateIndicators struct {
+	services *prometheus.Desc
+	conns    *prometheus.Desc
+	inBytes  *prometheus.Desc
+	outBytes *prometheus.Desc
+	inPkts   *prometheus.Desc
+	outPkts  *prometheus.Desc
+}
+
+func newAggregateIndicators(subsystem, label, what string) *aggregateIndicators {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help+" "+what+".", []string{label}, nil)
+	}
+	return &aggregateIndicators{
+		services: desc("services", "Number of exported services of"),
+		conns:    desc("conns_total", "Connections of"),
+		inBytes:  desc("in_bytes_total", "Incoming bytes of"),
+		outBytes: desc("out_bytes_total", "Outgoing bytes of"),
+		inPkts:   desc("in_pkts_total", "Incoming packets of"),
+		outPkts:  desc("out_pkts_total", "Outgoing packets of"),
+	}
+}
+
+var (
+	vipAggregates    = newAggregateIndicators("vip", "vip", "the exported services of a VIP, across ports and protocols")
+	rsHostAggregates = newAggregateIndicators("rs_host", "ip", "an RS host, across the exported services it backs")
+)
+
+// aggregate sums the stats of the services of a VIP or RS host.
+type aggregate struct {
+	services int
+	stats    [5]int64 // conns, in bytes, out bytes, in packets, out packets
+}
+
+func (a *aggregate) emit(ch chan<- prometheus.Metric, ai *aggregateIndicators, label string) {
+	ch <- prometheus.MustNewConstMetric(ai.services, prometheus.GaugeValue, float64(a.services), label)
+	for i, desc := range []*prometheus.Desc{ai.conns, ai.inBytes, ai.outBytes, ai.inPkts, ai.outPkts} {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(a.stats[i]), label)
+	}
+}
+
+// aggregateGroup identifies a VIP or RS host of a kind of aggregates.
+type aggregateGroup struct {
+	ai    *aggregateIndicators
+	label string
+}
+
+// aggregateMember identifies a service of a VIP, or a service and real
+// server of an RS host.
+type aggregateMember struct {
+	group aggregateGroup
+	key   string
+}
+
+// AggregateCollector exports the totals of the exported services per VIP and
+// per RS host, so that they don't need to be summed over every service and
+// real server at query time. Fwmark and SNAT match services have no VIP and
+// only count toward their RS hosts.
+//
+// The totals stay monotonic as services come and go: the last counters of a
+// member removed, or whose counters were reset, are carried forward. Totals
+// start over once a VIP or RS host has no member left.
+type AggregateCollector struct {
+	comm lb.Backend
+
+	mu      sync.Mutex
+	members map[aggregateMember][5]int64
+	retired map[aggregateGroup][5]int64
+}
+
+func NewAggregateCollector(comm lb.Backend) *AggregateCollector {
+	return &AggregateCollector{
+		comm:    comm,
+		members: make(map[aggregateMember][5]int64),
+		retired: make(map[aggregateGroup][5]int64),
+	}
+}
+
+func (c *AggregateCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, ai := range []*aggregateIndicators{vipAggregates, rsHostAggregates} {
+		ch <- ai.services
+		ch <- ai.conns
+		ch <- ai.inBytes
+		ch <- ai.outBytes
+		ch <- ai.inPkts
+		ch <- ai.outPkts
+	}
+}
+
+func (c *AggregateCollector) Update(ch chan<- prometheus.Metric) error {
+	services, err := c.comm.ListVirtualServices()
+	if err != nil || services == nil {
+		return err
+	}
+	members := make(map[aggregateMember][5]int64)
+	for _, vss := range services.Items {
+		key := GetServiceIdentifier(&vss)
+		if _, exists := connInfo[key]; !exists {
+			continue
+		}
+		if key == GetServerIdentifier(vss.Addr, vss.Port, vss.Proto) {
+			group := aggregateGroup{vipAggregates, canonicalIP(safeDereference(vss.Addr))}
+			members[aggregateMember{group, key}] = statsCounters(vss.Stats)
+		}
+		if vss.RSs == nil {
+			continue
+		}
+		for _, rs := range vss.RSs.Items {
+			if rs.Spec == nil {
+				continue
+			}
+			rsKey := GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)
+			if _, exists := connInfo[rsKey]; !exists {
+				continue
+			}
+			group := aggregateGroup{rsHostAggregates, canonicalIP(safeDereference(rs.Spec.IP))}
+			members[aggregateMember{group, key + "/" + rsKey}] = statsCounters(rs.Stats)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for m, prev := range c.members {
+		if cur, exists := members[m]; !exists || decreased(prev, cur) {
+			retired := c.retired[m.group]
+			for i := range retired {
+				retired[i] += prev[i]
+			}
+			c.retired[m.group] = retired
+		}
+	}
+	c.members = members
+
+	totals := make(map[aggregateGroup]*aggregate)
+	for m, counters := range members {
+		a := totals[m.group]
+		if a == nil {
+			a = &aggregate{stats: c.retired[m.group]}
+			totals[m.group] = a
+		}
+		a.services++
+		for i, v := range counters {
+			a.stats[i] += v
+		}
+	}
+	for group := range c.retired {
+		if totals[group] == nil {
+			delete(c.retired, group)
+		}
+	}
+	for group, a := range totals {
+		a.emit(ch, group.ai, group.label)
+	}
+	return nil
+}
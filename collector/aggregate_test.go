@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"testing"
+
+	"dpvs_exporter/lb"
+)
+
+func TestAggregateCollector(t *testing.T) {
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80, testServer("10.1.0.1", 1, 10, false)),
+		testService("10.0.0.1", 443, testServer("10.1.0.1", 1, 5, false), testServer("10.1.0.2", 1, 7, false)),
+	}}}
+	InitConnStatsController(backend.services.Items, nil)
+	c := NewAggregateCollector(backend)
+
+	if got := gather(t, c, vipAggregates.services)["vip=10.0.0.1"]; got != 2 {
+		t.Errorf("got %v services of the VIP, want 2", got)
+	}
+	conns := gather(t, c, rsHostAggregates.conns)
+	if conns["ip=10.1.0.1"] != 15 || conns["ip=10.1.0.2"] != 7 {
+		t.Errorf("unexpected RS host connections %v", conns)
+	}
+}
+
+func TestAggregateCollectorMonotonic(t *testing.T) {
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80, testServer("10.1.0.1", 1, 10, false)),
+		testService("10.0.0.1", 443, testServer("10.1.0.1", 1, 5, false)),
+	}}}
+	InitConnStatsController(backend.services.Items, nil)
+	c := NewAggregateCollector(backend)
+	gather(t, c, vipAggregates.conns)
+
+	// The 443 service is removed and the real server of the 80 one reset.
+	backend.services.Items = backend.services.Items[:1]
+	*backend.services.Items[0].RSs.Items[0].Stats.Conns = 2
+	if got := gather(t, c, rsHostAggregates.conns)["ip=10.1.0.1"]; got != 17 {
+		t.Errorf("got %v RS host connections, want 17", got)
+	}
+	if got := gather(t, c, vipAggregates.services)["vip=10.0.0.1"]; got != 1 {
+		t.Errorf("got %v services of the VIP, want 1", got)
+	}
+
+	backend.services.Items = nil
+	gather(t, c, vipAggregates.conns)
+	if len(c.retired) != 0 {
+		t.Errorf("kept the totals of VIPs and hosts without services: %v", c.retired)
+	}
+}
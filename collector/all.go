@@ -1,29 +1,325 @@
 package collector
 
 import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
 	"dpvs_exporter/lb"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// DefaultCollectorTimeout bounds a single sub-collector run within a scrape.
+const DefaultCollectorTimeout = 5 * time.Second
+
+var (
+	scrapeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+		"dpvs_exporter: Duration of a collector scrape.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+		"dpvs_exporter: Whether a collector succeeded.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// LimitedCollectors lists the collectors whose series can be limited with
+// Options.MaxSeries.
+var LimitedCollectors = []string{"conn", "nic", "share", "laddr", "acl", "accesslist", "toptalkers"}
+
+// apiCollector is implemented by collectors serving a JSON API next to their
+// metrics.
+type apiCollector interface {
+	APIPath() string
+	http.Handler
+}
+
+// subCollector is implemented by every collector driven by Dpvs. Update
+// reports failures instead of swallowing them so that they surface as
+// dpvs_scrape_collector_success.
+type subCollector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Update(ch chan<- prometheus.Metric) error
+}
+
+// Options tunes how Dpvs drives its sub-collectors.
+type Options struct {
+	// Timeout is the deadline of each sub-collector, DefaultCollectorTimeout
+	// if zero.
+	Timeout time.Duration
+	// MaxSeries caps the number of series exported by the named collector,
+	// which keeps whole entries, e.g. services or NICs, the busiest first;
+	// collectors without an entry, or with a non-positive one, are unlimited.
+	// Only the collectors listed by LimitedCollectors can be limited.
+	MaxSeries map[string]int
+	// MetadataFile is an optional service metadata mapping, see LoadMetadata.
+	MetadataFile string
+	// Enabled turns on the named optional collectors, e.g. "laddr".
+	Enabled map[string]bool
+	// Concurrency bounds the concurrent per-service or per-device requests of a collector,
+	// DefaultConcurrency if zero.
+	Concurrency int
+	// FNATPorts is the usable FNAT source port range, DefaultFNATPorts if
+	// empty.
+	FNATPorts PortRange
+	// ACLEntries exports an info series per allow/deny list entry.
+	ACLEntries bool
+	// ConnTable lists the connection table when the backend can't.
+	ConnTable lb.ConnLister
+	// ConnTableLimit caps the connection table entries read per scrape,
+	// DefaultConnTableLimit if zero.
+	ConnTableLimit int
+	// AccessLists lists the blacklists and whitelists when the backend
+	// can't.
+	AccessLists lb.AccessLister
+	// AccessListEntries exports an info series per blacklist and whitelist
+	// entry.
+	AccessListEntries bool
+	// EventHistory is the number of topology events served by /api/events,
+	// DefaultEventHistory if zero.
+	EventHistory int
+	// FlapWindow and FlapThreshold tune the flap detection,
+	// DefaultFlapWindow and DefaultFlapThreshold if zero.
+	FlapWindow    time.Duration
+	FlapThreshold int
+	// TopTalkers is the number of client prefixes exported per service,
+	// DefaultTopTalkers if zero.
+	TopTalkers int
+	// ProbeInterval is the interval at which dpvs-agent is probed again,
+	// DefaultProbeInterval if zero.
+	ProbeInterval time.Duration
+}
+
 type Dpvs struct {
-	conn *ConnStatsController
-	nic  *NicRateCollector
+	collectors map[string]subCollector
+	agent      *AgentCollector
+	opts       Options
+	dropped    *prometheus.CounterVec
 }
 
-func NewDpvs(agent lb.DpvsAgentComm) *Dpvs {
+// NewDpvs returns a Dpvs driving the default and enabled collectors. The
+// collectors querying endpoints only dpvs-agent serves are available with an
+// agent backend only, and run only while the agent serves the endpoints they
+// depend on; the agent is probed once here so that this is known from the
+// first scrape.
+func NewDpvs(backend lb.Backend, opts Options) *Dpvs {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultCollectorTimeout
+	}
+	services := newServiceCache(backend)
+	collectors := map[string]subCollector{
+		"conn":      NewConnStatsController(services),
+		"nic":       NewNicRateCollector(backend),
+		"bandwidth": NewBandwidthCollector(services),
+		"share":     NewShareCollector(services),
+		"aggregate": NewAggregateCollector(services),
+		"resets":    NewResetCollector(services),
+		"topology":  NewTopologyCollector(services, opts.EventHistory),
+		"flap":      NewFlapCollector(services, opts.FlapWindow, opts.FlapThreshold),
+	}
+	if opts.MetadataFile != "" {
+		collectors["metadata"] = NewMetadataCollector(opts.MetadataFile)
+	}
+	// The collectors reading the connection table share one listing.
+	var conns lb.ConnLister
+	if lister := connLister(backend, opts); lister != nil {
+		conns = newConnCache(lister)
+	}
+	if opts.Enabled["connstate"] {
+		if conns != nil {
+			collectors["connstate"] = NewConnStateCollector(conns, opts.ConnTableLimit)
+		} else {
+			log.Printf("Collector connstate requires a connection table source, disabled")
+		}
+	}
+	if opts.Enabled["toptalkers"] {
+		if conns != nil {
+			collectors["toptalkers"] = NewTopTalkersCollector(conns, opts.ConnTableLimit, opts.TopTalkers)
+		} else {
+			log.Printf("Collector toptalkers requires a connection table source, disabled")
+		}
+	}
+	if opts.Enabled["accesslist"] {
+		lists, ok := backend.(lb.AccessLister)
+		if !ok {
+			lists = opts.AccessLists
+		}
+		if lists != nil {
+			collectors["accesslist"] = NewAccessListCollector(services, lists, opts.AccessListEntries)
+		} else {
+			log.Printf("Collector accesslist requires a blacklist and whitelist source, disabled")
+		}
+	}
+	var ac *AgentCollector
+	if agent, ok := backend.(*lb.DpvsAgentComm); ok {
+		if opts.Enabled["laddr"] {
+			collectors["laddr"] = NewLaddrCollector(services, agent, opts.FNATPorts, opts.Concurrency)
+		}
+		if opts.Enabled["device"] {
+			collectors["device"] = NewDeviceCollector(agent, opts.Concurrency)
+		}
+		if opts.Enabled["ipset"] {
+			collectors["ipset"] = NewIpsetCollector(agent)
+		}
+		if opts.Enabled["acl"] {
+			collectors["acl"] = NewAclCollector(services, agent, opts.ACLEntries, opts.Concurrency)
+		}
+		ac = NewAgentCollector(agent, opts.ProbeInterval)
+		if _, err := ac.refresh(); err != nil {
+			log.Printf("Failed to probe dpvs-agent: %v", err)
+		}
+		collectors["agent"] = ac
+	} else {
+		for _, name := range []string{"laddr", "device", "ipset", "acl"} {
+			if opts.Enabled[name] {
+				log.Printf("Collector %s requires the dpvs-agent backend, disabled", name)
+			}
+		}
+	}
+	for name, limit := range opts.MaxSeries {
+		sc, enabled := collectors[name]
+		if _, limited := sc.(limitedCollector); enabled && limit > 0 && !limited {
+			log.Printf("Collector %s can't be limited, ignoring its series limit", name)
+		}
+	}
 	return &Dpvs{
-		conn: NewConnStatsController(&agent),
-		nic:  NewNicRateCollector(&agent),
+		collectors: collectors,
+		agent:      ac,
+		opts:       opts,
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "series_dropped_total",
+			Help:      "Number of series dropped because a collector exceeded its series limit.",
+		}, []string{"collector"}),
+	}
+}
+
+// RegisterAPI registers the JSON APIs of the enabled collectors on mux.
+func (c *Dpvs) RegisterAPI(mux *http.ServeMux) {
+	for _, sc := range c.collectors {
+		if api, ok := sc.(apiCollector); ok {
+			mux.Handle(api.APIPath(), api)
+		}
 	}
 }
 
+// connLister returns the connection table source, preferring the backend.
+func connLister(backend lb.Backend, opts Options) lb.ConnLister {
+	if conns, ok := backend.(lb.ConnLister); ok {
+		return conns
+	}
+	return opts.ConnTable
+}
+
+// Collect runs all sub-collectors in parallel, each with its own deadline.
 func (c *Dpvs) Collect(ch chan<- prometheus.Metric) {
-	c.conn.Collect(ch)
-	c.nic.Collect(ch)
+	wg := sync.WaitGroup{}
+	for name, sc := range c.collectors {
+		if !c.agent.Supported(name) {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, sc subCollector) {
+			defer wg.Done()
+			dropped, err := execute(name, sc, ch, c.opts.Timeout, c.opts.MaxSeries[name])
+			if dropped > 0 {
+				c.dropped.WithLabelValues(name).Add(float64(dropped))
+			}
+			// Probe the agent again once it is back.
+			if _, backed := collectorEndpoints[name]; backed && lb.IsUnreachable(err) {
+				c.agent.Invalidate()
+			}
+		}(name, sc)
+	}
+	wg.Wait()
+	c.dropped.Collect(ch)
 }
 
 func (c *Dpvs) Describe(ch chan<- *prometheus.Desc) {
-	c.conn.Describe(ch)
-	c.nic.Describe(ch)
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+	c.dropped.Describe(ch)
+	for _, sc := range c.collectors {
+		sc.Describe(ch)
+	}
+}
+
+// execute runs one sub-collector and forwards its metrics only if it finished
+// before the deadline, so a slow collector never yields a partial series set.
+// A collector that times out is left to finish in the background; its output
+// is discarded. A limited collector exports at most maxSeries series; the
+// number it dropped is returned along with its failure, if any.
+func execute(name string, sc subCollector, ch chan<- prometheus.Metric, timeout time.Duration, maxSeries int) (dropped int, err error) {
+	type result struct {
+		dropped int
+		err     error
+	}
+	begin := time.Now()
+	metrics := make(chan prometheus.Metric)
+	resc := make(chan result, 1)
+	go func() {
+		var res result
+		defer func() {
+			if r := recover(); r != nil {
+				res.err = fmt.Errorf("panic: %v", r)
+			}
+			close(metrics)
+			resc <- res
+		}()
+		if lc, ok := sc.(limitedCollector); ok {
+			res.dropped, res.err = lc.UpdateLimited(metrics, maxSeries)
+		} else {
+			res.err = sc.Update(metrics)
+		}
+	}()
+
+	var (
+		buf   []prometheus.Metric
+		timer = time.NewTimer(timeout)
+	)
+	defer timer.Stop()
+loop:
+	for {
+		select {
+		case m, ok := <-metrics:
+			if !ok {
+				res := <-resc
+				dropped, err = res.dropped, res.err
+				break loop
+			}
+			buf = append(buf, m)
+		case <-timer.C:
+			err = fmt.Errorf("timed out after %s", timeout)
+			go func() {
+				for range metrics {
+				}
+			}()
+			break loop
+		}
+	}
+	duration := time.Since(begin)
+
+	var success float64
+	if err != nil {
+		log.Printf("collector %s failed after %fs: %v", name, duration.Seconds(), err)
+		dropped = 0
+	} else {
+		success = 1
+		if dropped > 0 {
+			log.Printf("collector %s exceeded its limit of %d series, dropped %d", name, maxSeries, dropped)
+		}
+		for _, m := range buf {
+			ch <- m
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	return dropped, err
 }
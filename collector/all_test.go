@@ -0,0 +1,207 @@
+package collector
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var testDesc = prometheus.NewDesc("dpvs_test_value", "Test value.", nil, nil)
+
+type fakeCollector struct {
+	delay  time.Duration
+	err    error
+	series int
+	// entry is the number of series per entry of UpdateLimited, 1 if zero.
+	entry int
+}
+
+func (f *fakeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- testDesc
+}
+
+func (f *fakeCollector) Update(ch chan<- prometheus.Metric) error {
+	_, err := f.UpdateLimited(ch, 0)
+	return err
+}
+
+func (f *fakeCollector) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
+	time.Sleep(f.delay)
+	var entries []rankedEntry
+	for i := 0; i < max(f.series, 1); i++ {
+		if i%max(f.entry, 1) == 0 {
+			entries = append(entries, rankedEntry{weight: float64(i)})
+		}
+		e := &entries[len(entries)-1]
+		e.metrics = append(e.metrics, prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, 1))
+	}
+	return emitRanked(ch, entries, maxSeries), f.err
+}
+
+func runExecute(sc subCollector, timeout time.Duration, maxSeries int) (values, dropped int, success float64) {
+	ch := make(chan prometheus.Metric, 16)
+	dropped, _ = execute("fake", sc, ch, timeout, maxSeries)
+	close(ch)
+	for m := range ch {
+		switch m.Desc() {
+		case testDesc:
+			values++
+		case scrapeSuccessDesc:
+			var pb dto.Metric
+			m.Write(&pb)
+			success = pb.GetGauge().GetValue()
+		}
+	}
+	return values, dropped, success
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name        string
+		collector   *fakeCollector
+		maxSeries   int
+		wantValues  int
+		wantDropped int
+		wantSuccess float64
+	}{
+		{"ok", &fakeCollector{}, 0, 1, 0, 1},
+		{"error", &fakeCollector{err: errors.New("agent unreachable")}, 0, 0, 0, 0},
+		{"timeout", &fakeCollector{delay: time.Second}, 0, 0, 0, 0},
+		{"limit", &fakeCollector{series: 5}, 3, 3, 2, 1},
+		{"whole entries", &fakeCollector{series: 6, entry: 2}, 5, 4, 2, 1},
+		{"error past limit", &fakeCollector{series: 5, err: errors.New("agent unreachable")}, 3, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		values, dropped, success := runExecute(tt.collector, 50*time.Millisecond, tt.maxSeries)
+		if values != tt.wantValues || dropped != tt.wantDropped || success != tt.wantSuccess {
+			t.Errorf("%s: got %d values, %d dropped, success %v; want %d, %d, %v",
+				tt.name, values, dropped, success, tt.wantValues, tt.wantDropped, tt.wantSuccess)
+		}
+	}
+}
+
+func TestParseSeriesLimits(t *testing.T) {
+	limits, err := ParseSeriesLimits("conn=100, share=0")
+	if err != nil || len(limits) != 2 || limits["conn"] != 100 || limits["share"] != 0 {
+		t.Errorf("got %v, %v", limits, err)
+	}
+	for _, s := range []string{"conn", "conn=-1", "conn=many", "topology=10"} {
+		if _, err := ParseSeriesLimits(s); err == nil {
+			t.Errorf("ParseSeriesLimits(%q) succeeded", s)
+		}
+	}
+}
+
+// collected returns the collectors run by a scrape of c.
+func collected(c *Dpvs) map[string]bool {
+	ch := make(chan prometheus.Metric, 1024)
+	c.Collect(ch)
+	close(ch)
+	ran := make(map[string]bool)
+	for m := range ch {
+		if m.Desc() != scrapeSuccessDesc {
+			continue
+		}
+		var pb dto.Metric
+		m.Write(&pb)
+		ran[pb.GetLabel()[0].GetValue()] = true
+	}
+	return ran
+}
+
+// ipsetAgent is a dpvs-agent without services nor NICs, serving ipsets
+// while ipset is set.
+func ipsetAgent(ipset *atomic.Bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/vs", "/v2/device/name/nic":
+			w.Write([]byte(`{}`))
+		case "/v2/ipset":
+			if !ipset.Load() {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestDpvsAgentEndpoints(t *testing.T) {
+	var ipset atomic.Bool
+	srv := httptest.NewServer(ipsetAgent(&ipset))
+	defer srv.Close()
+	agent := lb.NewDpvsAgentComm(strings.TrimPrefix(srv.URL, "http://"))
+	InitConnStatsController(nil, nil)
+
+	// The ipset collector is left out while the agent doesn't serve ipsets.
+	c := NewDpvs(agent, Options{Enabled: map[string]bool{"ipset": true}, ProbeInterval: time.Hour})
+	if ran := collected(c); ran["ipset"] || !ran["conn"] || !ran["agent"] {
+		t.Fatalf("unexpected collectors %v", ran)
+	}
+	// And until the agent is probed again.
+	ipset.Store(true)
+	if ran := collected(c); ran["ipset"] {
+		t.Fatalf("unexpected collectors %v before the agent is probed again", ran)
+	}
+	c.agent.mu.Lock()
+	c.agent.probed = time.Now().Add(-time.Hour)
+	c.agent.mu.Unlock()
+	// The scrape probing the agent again decides on the next one.
+	collected(c)
+	if ran := collected(c); !ran["ipset"] {
+		t.Errorf("unexpected collectors %v after the agent was probed again", ran)
+	}
+}
+
+func TestAgentCollectorReconnect(t *testing.T) {
+	var ipset atomic.Bool
+	handler := ipsetAgent(&ipset)
+	srv := httptest.NewServer(handler)
+	addr := srv.Listener.Addr().String()
+	agent := lb.NewDpvsAgentComm(addr)
+	InitConnStatsController(nil, nil)
+	c := NewDpvs(agent, Options{Enabled: map[string]bool{"ipset": true}, ProbeInterval: time.Hour})
+	if !c.agent.Supported("conn") || c.agent.Supported("ipset") {
+		t.Fatal("unexpected endpoints")
+	}
+
+	// The agent goes down, and comes back upgraded.
+	srv.Close()
+	collected(c)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("can't listen on %s again: %v", addr, err)
+	}
+	srv = &httptest.Server{Listener: l, Config: &http.Server{Handler: handler}}
+	srv.Start()
+	defer srv.Close()
+	ipset.Store(true)
+	collected(c)
+	if ran := collected(c); !ran["ipset"] {
+		t.Errorf("unexpected collectors %v after the agent came back", ran)
+	}
+
+	// Failing replies don't probe the agent again.
+	c.agent.mu.Lock()
+	probed := c.agent.probed
+	c.agent.mu.Unlock()
+	ipset.Store(false)
+	collected(c)
+	c.agent.mu.Lock()
+	defer c.agent.mu.Unlock()
+	if !c.agent.probed.Equal(probed) {
+		t.Error("the agent was probed again after a failing reply")
+	}
+}
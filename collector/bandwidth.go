@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	bandwidthLimitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "bandwidth_limit_bytes_per_second"),
+		"Configured bandwidth limit (Bps) of a virtual service.",
+		[]string{"vs"},
+		nil,
+	)
+	bandwidthLimitProportionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "bandwidth_limit_proportion"),
+		"Configured LimitProportion of a virtual service, as a ratio.",
+		[]string{"vs"},
+		nil,
+	)
+	bandwidthUtilizationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "bandwidth_limit_utilization_ratio"),
+		"Current rate of a virtual service in a direction divided by its bandwidth limit.",
+		[]string{"vs", "direction"},
+		nil,
+	)
+)
+
+// BandwidthCollector exports the bandwidth limit of the exported virtual
+// services that have one, and how close their current rates are to it. The
+// rates (InBps, OutBps) and the limit (Bps) are both in bytes per second.
+type BandwidthCollector struct {
+	comm lb.Backend
+}
+
+func NewBandwidthCollector(comm lb.Backend) *BandwidthCollector {
+	return &BandwidthCollector{
+		comm: comm,
+	}
+}
+
+func (c *BandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bandwidthLimitDesc
+	ch <- bandwidthLimitProportionDesc
+	ch <- bandwidthUtilizationDesc
+}
+
+func (c *BandwidthCollector) Update(ch chan<- prometheus.Metric) error {
+	services, err := c.comm.ListVirtualServices()
+	if err != nil || services == nil {
+		return err
+	}
+	for _, vss := range services.Items {
+		limit := safeDereferenceInt64(vss.Bps)
+		if limit <= 0 {
+			continue
+		}
+		key := GetServiceIdentifier(&vss)
+		if _, exists := connInfo[key]; !exists {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(bandwidthLimitDesc, prometheus.GaugeValue, float64(limit), key)
+		if vss.LimitProportion != nil {
+			ch <- prometheus.MustNewConstMetric(bandwidthLimitProportionDesc, prometheus.GaugeValue, float64(*vss.LimitProportion)/100, key)
+		}
+		stats := vss.Stats
+		if stats == nil {
+			stats = &lb.ServerStats{}
+		}
+		ch <- prometheus.MustNewConstMetric(bandwidthUtilizationDesc, prometheus.GaugeValue, float64(safeDereferenceInt64(stats.InBps))/float64(limit), key, "in")
+		ch <- prometheus.MustNewConstMetric(bandwidthUtilizationDesc, prometheus.GaugeValue, float64(safeDereferenceInt64(stats.OutBps))/float64(limit), key, "out")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"dpvs_exporter/lb"
+)
+
+func TestBandwidthCollector(t *testing.T) {
+	limited := func(addr string, bps, proportion, inBps, outBps int64) lb.VirtualServerSpecExpand {
+		vss := testService(addr, 80)
+		vss.Bps, vss.Stats.InBps, vss.Stats.OutBps = &bps, &inBps, &outBps
+		if proportion >= 0 {
+			vss.LimitProportion = &proportion
+		}
+		return vss
+	}
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		limited("10.0.0.1", 1000, 80, 250, 500),
+		limited("10.0.0.2", 2000, -1, 3000, 0), // above its limit, no proportion
+		limited("10.0.0.3", 0, 50, 100, 100),   // unlimited
+		limited("10.0.0.4", 1000, 80, 100, 100),
+	}}}
+	InitConnStatsController(backend.services.Items[:3], nil) // 10.0.0.4 is not exported
+	c := NewBandwidthCollector(backend)
+
+	tests := []struct {
+		name string
+		got  map[string]float64
+		want map[string]float64
+	}{
+		{
+			"limit",
+			gather(t, c, bandwidthLimitDesc),
+			map[string]float64{"vs=10.0.0.1:80:TCP": 1000, "vs=10.0.0.2:80:TCP": 2000},
+		},
+		{
+			// LimitProportion is a percentage, exported as a ratio.
+			"proportion",
+			gather(t, c, bandwidthLimitProportionDesc),
+			map[string]float64{"vs=10.0.0.1:80:TCP": 0.8},
+		},
+		{
+			"utilization",
+			gather(t, c, bandwidthUtilizationDesc),
+			map[string]float64{
+				"direction=in,vs=10.0.0.1:80:TCP":  0.25,
+				"direction=out,vs=10.0.0.1:80:TCP": 0.5,
+				"direction=in,vs=10.0.0.2:80:TCP":  1.5,
+				"direction=out,vs=10.0.0.2:80:TCP": 0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestBandwidthCollectorError(t *testing.T) {
+	c := NewBandwidthCollector(&fakeBackend{err: errors.New("agent unreachable")})
+	if err := c.Update(nil); err == nil {
+		t.Error("Update succeeded despite the backend failing")
+	}
+}
@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"dpvs_exporter/lb"
+)
+
+// scrapeCacheTTL is how long listed services and connections are reused,
+// short enough to only be shared by the collectors of one scrape.
+const scrapeCacheTTL = time.Second
+
+// serviceCache shares the services listed by a backend between the
+// collectors of a scrape, so that the collectors deriving their metrics from
+// them don't each query the backend. Failures aren't cached.
+type serviceCache struct {
+	lb.Backend
+
+	mu       sync.Mutex
+	at       time.Time
+	services *lb.VsResponse
+}
+
+func newServiceCache(backend lb.Backend) *serviceCache {
+	return &serviceCache{Backend: backend}
+}
+
+func (c *serviceCache) ListVirtualServices() (*lb.VsResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.services != nil && time.Since(c.at) < scrapeCacheTTL {
+		return c.services, nil
+	}
+	services, err := c.Backend.ListVirtualServices()
+	if err != nil {
+		return nil, err
+	}
+	c.services, c.at = services, time.Now()
+	return services, nil
+}
+
+// connCache shares the connection table listed by a ConnLister between the
+// collectors of a scrape, like serviceCache. Listings with another limit
+// than the cached one aren't shared.
+type connCache struct {
+	conns lb.ConnLister
+
+	mu        sync.Mutex
+	at        time.Time
+	limit     int
+	entries   []lb.ConnEntry
+	truncated bool
+}
+
+func newConnCache(conns lb.ConnLister) *connCache {
+	return &connCache{conns: conns}
+}
+
+func (c *connCache) ListConns(limit int) ([]lb.ConnEntry, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.at.IsZero() && c.limit == limit && time.Since(c.at) < scrapeCacheTTL {
+		return c.entries, c.truncated, nil
+	}
+	entries, truncated, err := c.conns.ListConns(limit)
+	if err != nil {
+		return nil, false, err
+	}
+	c.at, c.limit, c.entries, c.truncated = time.Now(), limit, entries, truncated
+	return entries, truncated, nil
+}
@@ -2,8 +2,11 @@ package collector
 
 import (
 	"fmt"
+	"net"
+	"strings"
 
 	"dpvs_exporter/lb"
+	"dpvs_exporter/utils"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -19,11 +22,66 @@ type ConnectionIndicators struct {
 	outPkts  *prometheus.Desc
 }
 
+// connEntry pairs the stats of a VS or RS with its indicators.
+type connEntry struct {
+	key   string
+	ci    *ConnectionIndicators
+	stats *lb.ServerStats
+}
+
+func (e connEntry) traffic() float64 {
+	return statsTraffic(e.stats)
+}
+
+// statsTraffic returns the bytes carried by a VS or RS, by which limited
+// collectors rank their entries.
+func statsTraffic(stats *lb.ServerStats) float64 {
+	if stats == nil {
+		return 0
+	}
+	return float64(safeDereferenceInt64(stats.InBytes) + safeDereferenceInt64(stats.OutBytes))
+}
+
+func (e connEntry) ranked() rankedEntry {
+	stats := e.stats
+	if stats == nil {
+		stats = &lb.ServerStats{}
+	}
+	return rankedEntry{e.traffic(), []prometheus.Metric{
+		prometheus.MustNewConstMetric(e.ci.conns, prometheus.CounterValue, float64(safeDereferenceInt64(stats.Conns)), e.key),
+		prometheus.MustNewConstMetric(e.ci.inBytes, prometheus.CounterValue, float64(safeDereferenceInt64(stats.InBytes)), e.key),
+		prometheus.MustNewConstMetric(e.ci.outBytes, prometheus.CounterValue, float64(safeDereferenceInt64(stats.OutBytes)), e.key),
+		prometheus.MustNewConstMetric(e.ci.inPkts, prometheus.CounterValue, float64(safeDereferenceInt64(stats.InPkts)), e.key),
+		prometheus.MustNewConstMetric(e.ci.outPkts, prometheus.CounterValue, float64(safeDereferenceInt64(stats.OutPkts)), e.key),
+	}}
+}
+
+// addStats returns the sum of a and b, either of which may be nil.
+func addStats(a, b *lb.ServerStats) *lb.ServerStats {
+	sum := func(x, y *int64) *int64 {
+		v := safeDereferenceInt64(x) + safeDereferenceInt64(y)
+		return &v
+	}
+	if a == nil {
+		a = &lb.ServerStats{}
+	}
+	if b == nil {
+		b = &lb.ServerStats{}
+	}
+	return &lb.ServerStats{
+		Conns:    sum(a.Conns, b.Conns),
+		InBytes:  sum(a.InBytes, b.InBytes),
+		OutBytes: sum(a.OutBytes, b.OutBytes),
+		InPkts:   sum(a.InPkts, b.InPkts),
+		OutPkts:  sum(a.OutPkts, b.OutPkts),
+	}
+}
+
 type ConnStatsController struct {
-	comm *lb.DpvsAgentComm
+	comm lb.Backend
 }
 
-func NewConnStatsController(agent *lb.DpvsAgentComm) *ConnStatsController {
+func NewConnStatsController(agent lb.Backend) *ConnStatsController {
 	return &ConnStatsController{
 		comm: agent,
 	}
@@ -40,125 +98,134 @@ func (c *ConnStatsController) Describe(ch chan<- *prometheus.Desc) {
 	}
 }
 
-func (c *ConnStatsController) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("[panic] connRateCollector: %v", r)
-		}
-	}()
+func (c *ConnStatsController) Update(ch chan<- prometheus.Metric) error {
+	_, err := c.UpdateLimited(ch, 0)
+	return err
+}
+
+// UpdateLimited keeps the VSs and RSs carrying the most traffic, with all
+// their series, when they exceed maxSeries.
+func (c *ConnStatsController) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
 	services, err := c.comm.ListVirtualServices()
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return 0, err
 	}
 	if services == nil {
 		DefaultEmitMissingMetrics(ch, connInfo)
-		return
+		return 0, nil
 	}
+	var entries []connEntry
+	// A real server backing several services is identified by its address,
+	// port and protocol; its series sum its stats over the selected services.
+	servers := make(map[string]int)
 	for _, vss := range services.Items {
-		key := GetServerIdentifier(vss.Addr, vss.Port, vss.Proto)
+		key := GetServiceIdentifier(&vss)
 		ci, exists := connInfo[key]
-		if exists {
-			ch <- prometheus.MustNewConstMetric(ci.conns, prometheus.CounterValue, float64(safeDereferenceInt64(vss.Stats.Conns)), key)
-			ch <- prometheus.MustNewConstMetric(ci.inBytes, prometheus.CounterValue, float64(safeDereferenceInt64(vss.Stats.InBytes)), key)
-			ch <- prometheus.MustNewConstMetric(ci.outBytes, prometheus.CounterValue, float64(safeDereferenceInt64(vss.Stats.OutBytes)), key)
-			ch <- prometheus.MustNewConstMetric(ci.inPkts, prometheus.CounterValue, float64(safeDereferenceInt64(vss.Stats.InPkts)), key)
-			ch <- prometheus.MustNewConstMetric(ci.outPkts, prometheus.CounterValue, float64(safeDereferenceInt64(vss.Stats.OutPkts)), key)
+		if !exists {
+			// The service is filtered out, so are its stats on its RSs.
+			continue
 		}
+		entries = append(entries, connEntry{key, ci, vss.Stats})
 		if vss.RSs != nil {
 			for _, rs := range vss.RSs.Items {
+				if rs.Spec == nil {
+					continue
+				}
 				rsKey := GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)
 				cii, exists := connInfo[rsKey]
-				if exists {
-					ch <- prometheus.MustNewConstMetric(cii.conns, prometheus.CounterValue, float64(safeDereferenceInt64(rs.Stats.Conns)), rsKey)
-					ch <- prometheus.MustNewConstMetric(cii.inBytes, prometheus.CounterValue, float64(safeDereferenceInt64(rs.Stats.InBytes)), rsKey)
-					ch <- prometheus.MustNewConstMetric(cii.outBytes, prometheus.CounterValue, float64(safeDereferenceInt64(rs.Stats.OutBytes)), rsKey)
-					ch <- prometheus.MustNewConstMetric(cii.inPkts, prometheus.CounterValue, float64(safeDereferenceInt64(rs.Stats.InPkts)), rsKey)
-					ch <- prometheus.MustNewConstMetric(cii.outPkts, prometheus.CounterValue, float64(safeDereferenceInt64(rs.Stats.OutPkts)), rsKey)
+				if !exists {
+					continue
+				}
+				if i, seen := servers[rsKey]; seen {
+					entries[i].stats = addStats(entries[i].stats, rs.Stats)
+					continue
 				}
+				servers[rsKey] = len(entries)
+				entries = append(entries, connEntry{rsKey, cii, addStats(nil, rs.Stats)})
 			}
 		}
 	}
+	ranked := make([]rankedEntry, len(entries))
+	for i, e := range entries {
+		ranked[i] = e.ranked()
+	}
+	return emitRanked(ch, ranked, maxSeries), nil
 }
 
-func InitConnStatsController(services []lb.VirtualServerSpecExpand) {
+// InitConnStatsController builds the indicators of the services selected by
+// filter; a nil filter selects all of them.
+func InitConnStatsController(services []lb.VirtualServerSpecExpand, filter *ConnFilter) {
 	connInfo = make(map[string]*ConnectionIndicators)
 
 	for _, vss := range services {
-		key := GetServerIdentifier(vss.Addr, vss.Port, vss.Proto)
-		connInfo[key] = &ConnectionIndicators{
-			conns: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, "conn", key+"_conns"),
-				"VIP connections",
-				[]string{"conns"},
-				nil,
-			),
-			inBytes: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, "conn", key+"_in_bytes"),
-				"Incoming bytes for VIP",
-				[]string{"inBytes"},
-				nil,
-			),
-			outBytes: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, "conn", key+"_out_bytes"),
-				"Outgoing bytes for VIP",
-				[]string{"outBytes"},
-				nil,
-			),
-			inPkts: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, "conn", key+"_in_pkts"),
-				"Incoming packets for VIP",
-				[]string{"inPkts"},
-				nil,
-			),
-			outPkts: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, "conn", key+"_out_pkts"),
-				"Outgoing packets for VIP",
-				[]string{"outPkts"},
-				nil,
-			),
+		if !filter.matchVS(&vss) {
+			continue
 		}
+		key := GetServiceIdentifier(&vss)
+		connInfo[key] = newConnectionIndicators(key, "VIP", serverAF(vss.AF, vss.Addr))
 		if vss.RSs != nil {
 			for _, rs := range vss.RSs.Items {
-				rsKey := GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)
-				connInfo[rsKey] = &ConnectionIndicators{
-					conns: prometheus.NewDesc(
-						prometheus.BuildFQName(namespace, "conn", rsKey+"_conns"),
-						"RS connections",
-						[]string{"conns"},
-						nil,
-					),
-					inBytes: prometheus.NewDesc(
-						prometheus.BuildFQName(namespace, "conn", rsKey+"_in_bytes"),
-						"Incoming bytes for RS",
-						[]string{"inBytes"},
-						nil,
-					),
-					outBytes: prometheus.NewDesc(
-						prometheus.BuildFQName(namespace, "conn", rsKey+"_out_bytes"),
-						"Outgoing bytes for RS",
-						[]string{"outBytes"},
-						nil,
-					),
-					inPkts: prometheus.NewDesc(
-						prometheus.BuildFQName(namespace, "conn", rsKey+"_in_pkts"),
-						"Incoming packets for RS",
-						[]string{"inPkts"},
-						nil,
-					),
-					outPkts: prometheus.NewDesc(
-						prometheus.BuildFQName(namespace, "conn", rsKey+"_out_pkts"),
-						"Outgoing packets for RS",
-						[]string{"outPkts"},
-						nil,
-					),
+				if rs.Spec == nil || !filter.matchRS(&rs) {
+					continue
 				}
+				rsKey := GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)
+				connInfo[rsKey] = newConnectionIndicators(rsKey, "RS", serverAF(nil, rs.Spec.IP))
 			}
 		}
 	}
 }
 
-// 拼接 addr, port 和 proto 成 ip:port:proto 格式的函数
+func newConnectionIndicators(key, role string, af utils.AF) *ConnectionIndicators {
+	constLabels := prometheus.Labels{"af": af.String()}
+	return &ConnectionIndicators{
+		conns: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "conn", key+"_conns"),
+			role+" connections",
+			[]string{"conns"},
+			constLabels,
+		),
+		inBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "conn", key+"_in_bytes"),
+			"Incoming bytes for "+role,
+			[]string{"inBytes"},
+			constLabels,
+		),
+		outBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "conn", key+"_out_bytes"),
+			"Outgoing bytes for "+role,
+			[]string{"outBytes"},
+			constLabels,
+		),
+		inPkts: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "conn", key+"_in_pkts"),
+			"Incoming packets for "+role,
+			[]string{"inPkts"},
+			constLabels,
+		),
+		outPkts: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "conn", key+"_out_pkts"),
+			"Outgoing packets for "+role,
+			[]string{"outPkts"},
+			constLabels,
+		),
+	}
+}
+
+// serverAF returns the address family of a server, preferring the one
+// reported by the agent over the one implied by its address.
+func serverAF(af *int64, addr *string) utils.AF {
+	if af != nil && (utils.AF(*af) == utils.IPv4 || utils.AF(*af) == utils.IPv6) {
+		return utils.AF(*af)
+	}
+	if addr != nil {
+		if ip := net.ParseIP(*addr); ip != nil {
+			return utils.NewIP(ip).AF()
+		}
+	}
+	return utils.IPv4
+}
+
+// 拼接 addr, port 和 proto 成 ip:port:proto 格式的函数，IPv6 为 [ip]:port:proto
 func GetServerIdentifier(addr *string, port *int64, proto *int64) string {
 	// 如果 addr 是 nil，使用默认的 IP 地址
 	if addr == nil {
@@ -178,14 +245,65 @@ func GetServerIdentifier(addr *string, port *int64, proto *int64) string {
 		*proto = 6 // 默认值
 	}
 
-	// 根据 proto 值判断是 TCP 还是 UDP
-	protoStr := "TCP" // 默认是 TCP
-	if *proto == 17 {
-		protoStr = "UDP"
+	// 协议名称，如 TCP、UDP、SCTP、ICMP、ICMPv6
+	protoStr := utils.IPProto(*proto).String()
+
+	// IPv6 地址规范化并加方括号，避免与端口、协议分隔符混淆
+	host := canonicalIP(*addr)
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
 	}
 
 	// 拼接结果并返回
-	return fmt.Sprintf("%s:%d:%s", *addr, *port, protoStr)
+	return fmt.Sprintf("%s:%d:%s", host, *port, protoStr)
+}
+
+// GetServiceIdentifier returns the identifier of a virtual service. Services
+// keyed by address and port use GetServerIdentifier, while fwmark services are
+// identified as fwmark:<mark>:<af> and SNAT match services as
+// match:<proto>:<criteria>, e.g. match:TCP:src=10.0.0.1-10.0.0.9,oif=dpdk1.
+func GetServiceIdentifier(vss *lb.VirtualServerSpecExpand) string {
+	if mark := safeDereferenceInt64(vss.Fwmark); mark != 0 {
+		return fmt.Sprintf("fwmark:%d:%s", mark, serverAF(vss.AF, vss.Addr))
+	}
+	if vss.Match != nil {
+		var criteria []string
+		if r := vss.Match.Src; r != nil {
+			criteria = append(criteria, "src="+addrRange(r))
+		}
+		if r := vss.Match.Dest; r != nil {
+			criteria = append(criteria, "dst="+addrRange(r))
+		}
+		if name := safeDereference(vss.Match.InIfName); name != "" {
+			criteria = append(criteria, "iif="+name)
+		}
+		if name := safeDereference(vss.Match.OutIfName); name != "" {
+			criteria = append(criteria, "oif="+name)
+		}
+		if len(criteria) > 0 {
+			proto := utils.IPProtoTCP
+			if vss.Proto != nil {
+				proto = utils.IPProto(*vss.Proto)
+			}
+			return fmt.Sprintf("match:%s:%s", proto, strings.Join(criteria, ","))
+		}
+	}
+	return GetServerIdentifier(vss.Addr, vss.Port, vss.Proto)
+}
+
+func addrRange(r *lb.AddrRange) string {
+	start, end := canonicalIP(safeDereference(r.Start)), canonicalIP(safeDereference(r.End))
+	if end == "" || end == start {
+		return start
+	}
+	return start + "-" + end
+}
+
+func canonicalIP(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		return utils.NewIP(ip).String()
+	}
+	return addr
 }
 
 func DefaultEmitMissingMetrics(ch chan<- prometheus.Metric, connInfo map[string]*ConnectionIndicators) {
@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGetServerIdentifier(t *testing.T) {
+	tests := []struct {
+		addr  string
+		port  int64
+		proto int64
+		want  string
+	}{
+		{"10.0.0.1", 80, 6, "10.0.0.1:80:TCP"},
+		{"10.0.0.1", 53, 17, "10.0.0.1:53:UDP"},
+		{"10.0.0.1", 9000, 132, "10.0.0.1:9000:SCTP"},
+		{"10.0.0.1", 0, 1, "10.0.0.1:0:ICMP"},
+		{"2001:DB8:0:0::1", 80, 6, "[2001:db8::1]:80:TCP"},
+		{"::ffff:10.0.0.1", 80, 6, "10.0.0.1:80:TCP"},
+	}
+	for _, tt := range tests {
+		if got := GetServerIdentifier(&tt.addr, &tt.port, &tt.proto); got != tt.want {
+			t.Errorf("GetServerIdentifier(%q, %d, %d) = %q, want %q", tt.addr, tt.port, tt.proto, got, tt.want)
+		}
+	}
+}
+
+func TestGetServiceIdentifier(t *testing.T) {
+	str := func(s string) *string { return &s }
+	i64 := func(i int64) *int64 { return &i }
+	tests := []struct {
+		vs   lb.VirtualServerSpecExpand
+		want string
+	}{
+		{
+			lb.VirtualServerSpecExpand{Addr: str("10.0.0.1"), Port: i64(80), Proto: i64(6)},
+			"10.0.0.1:80:TCP",
+		},
+		{
+			lb.VirtualServerSpecExpand{AF: i64(10), Fwmark: i64(100)},
+			"fwmark:100:IPv6",
+		},
+		{
+			lb.VirtualServerSpecExpand{
+				Addr:  str("0.0.0.0"),
+				Proto: i64(17),
+				Match: &lb.MatchSpec{
+					Src:       &lb.AddrRange{Start: str("10.0.0.1"), End: str("10.0.0.9")},
+					OutIfName: str("dpdk1"),
+				},
+			},
+			"match:UDP:src=10.0.0.1-10.0.0.9,oif=dpdk1",
+		},
+	}
+	for _, tt := range tests {
+		if got := GetServiceIdentifier(&tt.vs); got != tt.want {
+			t.Errorf("GetServiceIdentifier() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestConnStatsSharedServer(t *testing.T) {
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80, testServer("10.1.0.1", 1, 10, false)),
+		testService("10.0.0.2", 80, testServer("10.1.0.1", 1, 5, false)),
+	}}}
+	InitConnStatsController(backend.services.Items, nil)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewDpvs(backend, Options{}))
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	rsKey := "10.1.0.1:80:TCP"
+	if got := gather(t, NewConnStatsController(backend), connInfo[rsKey].conns)["af=IPv4,conns="+rsKey]; got != 15 {
+		t.Errorf("got %v connections of the shared real server, want 15", got)
+	}
+}
+
+func TestConnStatsFilteredSharedServer(t *testing.T) {
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80, testServer("10.1.0.1", 1, 10, false)),
+		testService("10.0.0.2", 80, testServer("10.1.0.1", 1, 1000, false)),
+	}}}
+	nets, err := ParseCIDRList("10.0.0.1/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitConnStatsController(backend.services.Items, &ConnFilter{VIPInclude: nets})
+
+	if _, exists := connInfo["10.0.0.2:80:TCP"]; exists {
+		t.Fatal("excluded service has indicators")
+	}
+	rsKey := "10.1.0.1:80:TCP"
+	// Only the stats of the included service count on the shared RS.
+	if got := gather(t, NewConnStatsController(backend), connInfo[rsKey].conns)["af=IPv4,conns="+rsKey]; got != 10 {
+		t.Errorf("got %v connections of the shared real server, want 10", got)
+	}
+}
+
+func TestConnStatsLimit(t *testing.T) {
+	bytes := func(vss lb.VirtualServerSpecExpand, in int64) lb.VirtualServerSpecExpand {
+		vss.Stats.InBytes = &in
+		return vss
+	}
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		bytes(testService("10.0.0.1", 80), 10),
+		bytes(testService("10.0.0.2", 80), 30),
+		bytes(testService("10.0.0.3", 80), 20),
+	}}}
+	InitConnStatsController(backend.services.Items, nil)
+
+	ch := make(chan prometheus.Metric, 16)
+	dropped, err := NewConnStatsController(backend).UpdateLimited(ch, 12)
+	close(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for m := range ch {
+		got = append(got, m.Desc().String())
+	}
+	// Each service has 5 series: the 2 busiest ones fit, whole, in 12.
+	if len(got) != 10 || dropped != 5 {
+		t.Fatalf("got %d series, %d dropped; want 10, 5", len(got), dropped)
+	}
+	for i, vip := range []string{"10.0.0.2", "10.0.0.3"} {
+		for _, desc := range got[5*i : 5*i+5] {
+			if !strings.Contains(desc, vip) {
+				t.Errorf("series %s out of order, want %s", desc, vip)
+			}
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewDpvs(backend, Options{MaxSeries: map[string]int{"conn": 12}}))
+	for i := 0; i < 2; i++ {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dropped float64
+		for _, mf := range families {
+			if mf.GetName() == "dpvs_series_dropped_total" {
+				for _, m := range mf.GetMetric() {
+					if m.GetLabel()[0].GetValue() == "conn" {
+						dropped = m.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		if want := float64(5 * (i + 1)); dropped != want {
+			t.Errorf("scrape %d: got dpvs_series_dropped_total %v, want %v", i, dropped, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultConnTableLimit caps the connection table entries read per scrape.
+const DefaultConnTableLimit = 100000
+
+var (
+	connStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "conn", "state"),
+		"Connections of a virtual service in a state, from the sampled connection table.",
+		[]string{"vs", "state"},
+		nil,
+	)
+	rsConnStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rs", "conn_state"),
+		"Connections to a real server in a state, from the sampled connection table.",
+		[]string{"vs", "rs", "state"},
+		nil,
+	)
+	connTableEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "conn_table", "sampled_entries"),
+		"Number of connection table entries read.",
+		nil,
+		nil,
+	)
+	connTableTruncatedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "conn_table", "truncated"),
+		"Whether the connection table was larger than the sampling limit.",
+		nil,
+		nil,
+	)
+)
+
+// ConnStateCollector exports the distribution of connection states per
+// exported virtual service and real server.
+type ConnStateCollector struct {
+	conns lb.ConnLister
+	limit int
+}
+
+// NewConnStateCollector returns a ConnStateCollector reading at most limit
+// connections per scrape, DefaultConnTableLimit if limit is zero.
+func NewConnStateCollector(conns lb.ConnLister, limit int) *ConnStateCollector {
+	if limit == 0 {
+		limit = DefaultConnTableLimit
+	}
+	return &ConnStateCollector{
+		conns: conns,
+		limit: limit,
+	}
+}
+
+func (c *ConnStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connStateDesc
+	ch <- rsConnStateDesc
+	ch <- connTableEntriesDesc
+	ch <- connTableTruncatedDesc
+}
+
+func (c *ConnStateCollector) Update(ch chan<- prometheus.Metric) error {
+	conns, truncated, err := c.conns.ListConns(c.limit)
+	if err != nil {
+		return err
+	}
+
+	type rsState struct{ vs, rs, state string }
+	vsStates := make(map[[2]string]int)
+	rsStates := make(map[rsState]int)
+	for _, conn := range conns {
+		key := connServiceKey(&conn)
+		if _, exists := connInfo[key]; !exists {
+			continue
+		}
+		vsStates[[2]string{key, conn.State}]++
+		proto := int64(conn.Proto)
+		rsKey := GetServerIdentifier(&conn.Dest.IP, &conn.Dest.Port, &proto)
+		if _, exists := connInfo[rsKey]; exists {
+			rsStates[rsState{key, rsKey, conn.State}]++
+		}
+	}
+	for k, n := range vsStates {
+		ch <- prometheus.MustNewConstMetric(connStateDesc, prometheus.GaugeValue, float64(n), k[0], k[1])
+	}
+	for k, n := range rsStates {
+		ch <- prometheus.MustNewConstMetric(rsConnStateDesc, prometheus.GaugeValue, float64(n), k.vs, k.rs, k.state)
+	}
+
+	var t float64
+	if truncated {
+		t = 1
+	}
+	ch <- prometheus.MustNewConstMetric(connTableEntriesDesc, prometheus.GaugeValue, float64(len(conns)))
+	ch <- prometheus.MustNewConstMetric(connTableTruncatedDesc, prometheus.GaugeValue, t)
+	return nil
+}
+
+// connServiceKey returns the identifier of the virtual service of conn.
+func connServiceKey(conn *lb.ConnEntry) string {
+	proto := int64(conn.Proto)
+	return GetServerIdentifier(&conn.Virtual.IP, &conn.Virtual.Port, &proto)
+}
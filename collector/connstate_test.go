@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"dpvs_exporter/lb"
+	"dpvs_exporter/utils"
+)
+
+// sampledConns returns at most limit of its connections, recording the limit
+// asked for.
+type sampledConns struct {
+	conns []lb.ConnEntry
+	err   error
+	limit int
+}
+
+func (s *sampledConns) ListConns(limit int) ([]lb.ConnEntry, bool, error) {
+	s.limit = limit
+	if limit > 0 && len(s.conns) > limit {
+		return s.conns[:limit], true, s.err
+	}
+	return s.conns, false, s.err
+}
+
+func TestConnStateCollector(t *testing.T) {
+	conn := func(vip, rs, state string) lb.ConnEntry {
+		return lb.ConnEntry{
+			Proto:   utils.IPProtoTCP,
+			State:   state,
+			Client:  lb.ConnAddr{IP: "1.1.1.1", Port: 40000},
+			Virtual: lb.ConnAddr{IP: vip, Port: 80},
+			Dest:    lb.ConnAddr{IP: rs, Port: 80},
+		}
+	}
+	InitConnStatsController([]lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80, testServer("10.1.0.1", 1, 0, false)),
+	}, nil)
+	conns := &sampledConns{conns: []lb.ConnEntry{
+		conn("10.0.0.1", "10.1.0.1", "ESTABLISHED"),
+		conn("10.0.0.1", "10.1.0.1", "ESTABLISHED"),
+		conn("10.0.0.1", "10.1.0.1", "TIME_WAIT"),
+		conn("10.0.0.1", "10.1.0.2", "SYN_RECV"),    // real server not exported
+		conn("10.0.0.2", "10.1.0.1", "ESTABLISHED"), // service not exported
+	}}
+
+	c := NewConnStateCollector(conns, 0)
+	tests := []struct {
+		name string
+		got  map[string]float64
+		want map[string]float64
+	}{
+		{
+			"vs",
+			gather(t, c, connStateDesc),
+			map[string]float64{
+				"state=ESTABLISHED,vs=10.0.0.1:80:TCP": 2,
+				"state=TIME_WAIT,vs=10.0.0.1:80:TCP":   1,
+				"state=SYN_RECV,vs=10.0.0.1:80:TCP":    1,
+			},
+		},
+		{
+			"rs",
+			gather(t, c, rsConnStateDesc),
+			map[string]float64{
+				"rs=10.1.0.1:80:TCP,state=ESTABLISHED,vs=10.0.0.1:80:TCP": 2,
+				"rs=10.1.0.1:80:TCP,state=TIME_WAIT,vs=10.0.0.1:80:TCP":   1,
+			},
+		},
+		{"entries", gather(t, c, connTableEntriesDesc), map[string]float64{"": 5}},
+		{"truncated", gather(t, c, connTableTruncatedDesc), map[string]float64{"": 0}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if conns.limit != DefaultConnTableLimit {
+		t.Errorf("read %d connections, want %d", conns.limit, DefaultConnTableLimit)
+	}
+
+	c = NewConnStateCollector(conns, 2)
+	if got := gather(t, c, connTableTruncatedDesc)[""]; got != 1 {
+		t.Errorf("got truncated %v, want 1", got)
+	}
+	if got := gather(t, c, connTableEntriesDesc)[""]; got != 2 {
+		t.Errorf("got %v sampled entries, want 2", got)
+	}
+
+	conns.err = errors.New("ipvsadm: exit status 1")
+	if err := c.Update(nil); err == nil {
+		t.Error("Update succeeded despite the connection table failing")
+	}
+}
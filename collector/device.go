@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"fmt"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deviceAddrsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "device", "addresses"),
+		"Number of interface addresses configured on a device.",
+		[]string{"device"},
+		nil,
+	)
+	deviceRoutesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "device", "routes"),
+		"Number of routes via a device.",
+		[]string{"device"},
+		nil,
+	)
+	deviceVlansDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "device", "vlans"),
+		"Number of VLAN devices on top of a device.",
+		[]string{"device"},
+		nil,
+	)
+	deviceVlanInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "device", "vlan_info"),
+		"VLAN device on top of a device.",
+		[]string{"device", "vlan", "id"},
+		nil,
+	)
+)
+
+// DeviceCollector exports the address, route and VLAN inventory of every
+// exported NIC and of the VLAN devices on top of them. The KNI devices, whose
+// dpvs-agent endpoints reply with no documented model, are left out.
+type DeviceCollector struct {
+	comm        *lb.DpvsAgentComm
+	concurrency int
+}
+
+// NewDeviceCollector returns a DeviceCollector querying up to concurrency
+// devices at once, DefaultConcurrency if zero.
+func NewDeviceCollector(comm *lb.DpvsAgentComm, concurrency int) *DeviceCollector {
+	return &DeviceCollector{
+		comm:        comm,
+		concurrency: concurrency,
+	}
+}
+
+func (c *DeviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- deviceAddrsDesc
+	ch <- deviceRoutesDesc
+	ch <- deviceVlansDesc
+	ch <- deviceVlanInfoDesc
+}
+
+func (c *DeviceCollector) Update(ch chan<- prometheus.Metric) error {
+	var names []string
+	for name := range nics {
+		names = append(names, name)
+	}
+	vlans := make([][]lb.VlanSpec, len(names))
+	err := fanout(len(names), c.concurrency, func(i int) error {
+		var err error
+		if vlans[i], err = c.comm.ListDeviceVlans(names[i]); err != nil {
+			return fmt.Errorf("%s: %w", names[i], err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	devices := append([]string(nil), names...)
+	var metrics []prometheus.Metric
+	for i, name := range names {
+		metrics = append(metrics, prometheus.MustNewConstMetric(deviceVlansDesc, prometheus.GaugeValue, float64(len(vlans[i])), name))
+		for _, vlan := range vlans[i] {
+			vlanName := safeDereference(vlan.Name)
+			metrics = append(metrics, prometheus.MustNewConstMetric(deviceVlanInfoDesc, prometheus.GaugeValue, 1, name, vlanName, safeDereference(vlan.ID)))
+			devices = append(devices, vlanName)
+		}
+	}
+	// The addresses and routes of every device are requests of their own.
+	counts := make([]int, 2*len(devices))
+	err = fanout(len(counts), c.concurrency, func(i int) error {
+		name := devices[i/2]
+		if i%2 == 0 {
+			addrs, err := c.comm.ListDeviceAddrs(name)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			counts[i] = len(addrs)
+			return nil
+		}
+		routes, err := c.comm.ListDeviceRoutes(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		counts[i] = len(routes)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, name := range devices {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(deviceAddrsDesc, prometheus.GaugeValue, float64(counts[2*i]), name),
+			prometheus.MustNewConstMetric(deviceRoutesDesc, prometheus.GaugeValue, float64(counts[2*i+1]), name),
+		)
+	}
+	for _, m := range metrics {
+		ch <- m
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDeviceCollector(t *testing.T) {
+	str := func(s string) *string { return &s }
+	replies := map[string]interface{}{
+		"/v2/device/dpdk0/vlan": lb.VlanList{Items: []lb.VlanSpec{
+			{Device: str("dpdk0"), ID: str("100"), Name: str("dpdk0.100")},
+		}},
+		"/v2/device/dpdk0/addr": lb.InetAddrList{Items: []lb.InetAddrSpec{
+			{Addr: str("10.0.0.1/24")}, {Addr: str("10.0.0.2/24")},
+		}},
+		"/v2/device/dpdk0/route": lb.RouteList{Items: []lb.RouteSpec{
+			{Device: str("dpdk0")}, {Device: str("dpdk0")}, {Device: str("dpdk0")},
+		}},
+		"/v2/device/dpdk0.100/addr":  lb.InetAddrList{Items: []lb.InetAddrSpec{{Addr: str("10.1.0.1/24")}}},
+		"/v2/device/dpdk0.100/route": lb.RouteList{},
+	}
+	InitNicCollector([]string{"dpdk0"}, nil)
+	c := NewDeviceCollector(fakeAgent(t, replies), 2)
+
+	tests := []struct {
+		name string
+		got  map[string]float64
+		want map[string]float64
+	}{
+		{"vlans", gather(t, c, deviceVlansDesc), map[string]float64{"device=dpdk0": 1}},
+		{"vlan info", gather(t, c, deviceVlanInfoDesc), map[string]float64{"device=dpdk0,id=100,vlan=dpdk0.100": 1}},
+		{"addresses", gather(t, c, deviceAddrsDesc), map[string]float64{"device=dpdk0": 2, "device=dpdk0.100": 1}},
+		{"routes", gather(t, c, deviceRoutesDesc), map[string]float64{"device=dpdk0": 3, "device=dpdk0.100": 0}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// A VLAN device whose routes can't be listed fails the collector.
+	delete(replies, "/v2/device/dpdk0.100/route")
+	c = NewDeviceCollector(fakeAgent(t, replies), 2)
+	if err := c.Update(make(chan prometheus.Metric, 16)); err == nil {
+		t.Error("Update succeeded despite the agent failing")
+	}
+}
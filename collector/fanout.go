@@ -0,0 +1,49 @@
+package collector
+
+import "sync"
+
+// DefaultConcurrency bounds the concurrent per-service or per-device
+// requests of a collector, so that nodes with thousands of services are
+// queried within the collector timeout without flooding dpvs-agent.
+const DefaultConcurrency = 16
+
+// fanout calls fn for every index below n on at most workers goroutines and
+// returns the first error. Once an error occurred, the remaining indexes are
+// skipped.
+func fanout(n, workers int, fn func(i int) error) error {
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+		done  = make(chan struct{})
+		next  = make(chan int)
+	)
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(i); err != nil {
+					once.Do(func() {
+						first = err
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case next <- i:
+		case <-done:
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	return first
+}
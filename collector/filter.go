@@ -0,0 +1,201 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dpvs_exporter/lb"
+	"dpvs_exporter/utils"
+)
+
+// PortRange is an inclusive range of L4 ports.
+type PortRange struct {
+	Min, Max int64
+}
+
+func (r PortRange) contains(port int64) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// ConnFilter selects which virtual services and real servers are exported by
+// ConnStatsController. Empty include lists match everything.
+type ConnFilter struct {
+	VIPInclude   []*net.IPNet
+	VIPExclude   []*net.IPNet
+	PortInclude  []PortRange
+	PortExclude  []PortRange
+	Protocols    []utils.IPProto
+	RSInclude    []*net.IPNet
+	RSExclude    []*net.IPNet
+	VSAggregates bool // drop RS-level series, keep only VS aggregates
+}
+
+// NicFilter selects which NICs are exported by NicRateCollector.
+type NicFilter struct {
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+}
+
+func (f *ConnFilter) matchVS(vss *lb.VirtualServerSpecExpand) bool {
+	if f == nil {
+		return true
+	}
+	if vss.Addr != nil {
+		ip := net.ParseIP(*vss.Addr)
+		if !matchNets(ip, f.VIPInclude, f.VIPExclude) {
+			return false
+		}
+	} else if len(f.VIPInclude) > 0 {
+		return false
+	}
+	if !matchPorts(safeDereferenceInt64(vss.Port), f.PortInclude, f.PortExclude) {
+		return false
+	}
+	if len(f.Protocols) > 0 {
+		proto := utils.IPProto(safeDereferenceInt64(vss.Proto))
+		for _, p := range f.Protocols {
+			if p == proto {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (f *ConnFilter) matchRS(rs *lb.RealServerSpecExpand) bool {
+	if f == nil {
+		return true
+	}
+	if f.VSAggregates {
+		return false
+	}
+	if rs.Spec == nil || rs.Spec.IP == nil {
+		return len(f.RSInclude) == 0
+	}
+	return matchNets(net.ParseIP(*rs.Spec.IP), f.RSInclude, f.RSExclude)
+}
+
+func (f *NicFilter) match(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Include != nil && !f.Include.MatchString(name) {
+		return false
+	}
+	if f.Exclude != nil && f.Exclude.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+func matchNets(ip net.IP, include, exclude []*net.IPNet) bool {
+	if ip == nil {
+		return len(include) == 0
+	}
+	for _, n := range exclude {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, n := range include {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPorts(port int64, include, exclude []PortRange) bool {
+	for _, r := range exclude {
+		if r.contains(port) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, r := range include {
+		if r.contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRList parses a comma separated list of CIDRs. Bare addresses are
+// treated as host routes.
+func ParseCIDRList(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range splitList(s) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ParsePortRanges parses a comma separated list of ports and port ranges,
+// e.g. "80,443,8000-8100".
+func ParsePortRanges(s string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, item := range splitList(s) {
+		lo, hi, found := strings.Cut(item, "-")
+		if !found {
+			hi = lo
+		}
+		min, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		max, err := strconv.ParseUint(hi, 10, 16)
+		if err != nil || max < min {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+		ranges = append(ranges, PortRange{int64(min), int64(max)})
+	}
+	return ranges, nil
+}
+
+// ParseProtocols parses a comma separated list of protocol names, e.g. "tcp,udp".
+func ParseProtocols(s string) ([]utils.IPProto, error) {
+	var protos []utils.IPProto
+	for _, item := range splitList(s) {
+		proto := utils.IPProtoFromStr(item)
+		if proto == 0 {
+			return nil, fmt.Errorf("unknown protocol %q", item)
+		}
+		protos = append(protos, proto)
+	}
+	return protos, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,205 @@
+package collector
+
+import (
+	"net"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"dpvs_exporter/lb"
+	"dpvs_exporter/utils"
+)
+
+func mustCIDRs(t *testing.T, s string) []*net.IPNet {
+	t.Helper()
+	nets, err := ParseCIDRList(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nets
+}
+
+func TestConnFilterMatchVS(t *testing.T) {
+	vs := func(addr string, port, proto int64) *lb.VirtualServerSpecExpand {
+		vss := &lb.VirtualServerSpecExpand{Port: &port, Proto: &proto}
+		if addr != "" {
+			vss.Addr = &addr
+		}
+		return vss
+	}
+	tests := []struct {
+		name   string
+		filter *ConnFilter
+		vs     *lb.VirtualServerSpecExpand
+		want   bool
+	}{
+		{"nil filter", nil, vs("10.0.0.1", 80, 6), true},
+		{"empty filter", &ConnFilter{}, vs("10.0.0.1", 80, 6), true},
+		{"vip included", &ConnFilter{VIPInclude: mustCIDRs(t, "10.0.0.0/24")}, vs("10.0.0.1", 80, 6), true},
+		{"vip not included", &ConnFilter{VIPInclude: mustCIDRs(t, "10.0.1.0/24")}, vs("10.0.0.1", 80, 6), false},
+		{
+			"exclude over include",
+			&ConnFilter{VIPInclude: mustCIDRs(t, "10.0.0.0/8"), VIPExclude: mustCIDRs(t, "10.0.0.1")},
+			vs("10.0.0.1", 80, 6),
+			false,
+		},
+		{"ipv6 vip", &ConnFilter{VIPInclude: mustCIDRs(t, "2001:db8::/32")}, vs("2001:db8::1", 80, 6), true},
+		{"nil addr", &ConnFilter{}, vs("", 80, 6), true},
+		{"nil addr with include", &ConnFilter{VIPInclude: mustCIDRs(t, "10.0.0.0/8")}, vs("", 80, 6), false},
+		{"nil addr with exclude", &ConnFilter{VIPExclude: mustCIDRs(t, "10.0.0.0/8")}, vs("", 80, 6), true},
+		{"port included", &ConnFilter{PortInclude: []PortRange{{8000, 8100}}}, vs("10.0.0.1", 8080, 6), true},
+		{"port not included", &ConnFilter{PortInclude: []PortRange{{8000, 8100}}}, vs("10.0.0.1", 80, 6), false},
+		{
+			"port exclude over include",
+			&ConnFilter{PortInclude: []PortRange{{1, 65535}}, PortExclude: []PortRange{{80, 80}}},
+			vs("10.0.0.1", 80, 6),
+			false,
+		},
+		{"protocol", &ConnFilter{Protocols: []utils.IPProto{utils.IPProtoUDP}}, vs("10.0.0.1", 53, 17), true},
+		{"other protocol", &ConnFilter{Protocols: []utils.IPProto{utils.IPProtoUDP}}, vs("10.0.0.1", 80, 6), false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.matchVS(tt.vs); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConnFilterMatchRS(t *testing.T) {
+	rs := func(ip string) *lb.RealServerSpecExpand {
+		if ip == "" {
+			return &lb.RealServerSpecExpand{Spec: &lb.RealServerSpecTiny{}}
+		}
+		return &lb.RealServerSpecExpand{Spec: &lb.RealServerSpecTiny{IP: &ip}}
+	}
+	tests := []struct {
+		name   string
+		filter *ConnFilter
+		rs     *lb.RealServerSpecExpand
+		want   bool
+	}{
+		{"nil filter", nil, rs("192.168.1.10"), true},
+		{"empty filter", &ConnFilter{}, rs("192.168.1.10"), true},
+		{"vs aggregates only", &ConnFilter{VSAggregates: true}, rs("192.168.1.10"), false},
+		{"included", &ConnFilter{RSInclude: mustCIDRs(t, "192.168.1.0/24")}, rs("192.168.1.10"), true},
+		{"not included", &ConnFilter{RSInclude: mustCIDRs(t, "192.168.2.0/24")}, rs("192.168.1.10"), false},
+		{
+			"exclude over include",
+			&ConnFilter{RSInclude: mustCIDRs(t, "192.168.0.0/16"), RSExclude: mustCIDRs(t, "192.168.1.10")},
+			rs("192.168.1.10"),
+			false,
+		},
+		{"nil ip", &ConnFilter{RSExclude: mustCIDRs(t, "192.168.0.0/16")}, rs(""), true},
+		{"nil ip with include", &ConnFilter{RSInclude: mustCIDRs(t, "192.168.0.0/16")}, rs(""), false},
+		{"nil spec", &ConnFilter{}, &lb.RealServerSpecExpand{}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.matchRS(tt.rs); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNicFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *NicFilter
+		nic    string
+		want   bool
+	}{
+		{"nil filter", nil, "dpdk0", true},
+		{"empty filter", &NicFilter{}, "dpdk0", true},
+		{"included", &NicFilter{Include: regexp.MustCompile(`^dpdk`)}, "dpdk0", true},
+		{"not included", &NicFilter{Include: regexp.MustCompile(`^dpdk`)}, "bond0", false},
+		{"excluded", &NicFilter{Exclude: regexp.MustCompile(`\.kni$`)}, "dpdk0.kni", false},
+		{
+			"exclude over include",
+			&NicFilter{Include: regexp.MustCompile(`^dpdk`), Exclude: regexp.MustCompile(`1$`)},
+			"dpdk1",
+			false,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.match(tt.nic); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseCIDRList(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"10.0.0.0/8, 2001:db8::/32", []string{"10.0.0.0/8", "2001:db8::/32"}, false},
+		{"10.0.0.1", []string{"10.0.0.1/32"}, false},
+		{"2001:db8::1", []string{"2001:db8::1/128"}, false},
+		{"::ffff:10.0.0.1", []string{"10.0.0.1/32"}, false},
+		{"10.0.0.0/33", nil, true},
+		{"web-01", nil, true},
+	}
+	for _, tt := range tests {
+		nets, err := ParseCIDRList(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCIDRList(%q): got error %v", tt.in, err)
+			continue
+		}
+		var got []string
+		for _, n := range nets {
+			got = append(got, n.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCIDRList(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	// Bare addresses are host routes, which contain no other address.
+	nets := mustCIDRs(t, "10.0.0.1")
+	if !nets[0].Contains(net.ParseIP("10.0.0.1")) || nets[0].Contains(net.ParseIP("10.0.0.2")) {
+		t.Errorf("%v is not a host route", nets[0])
+	}
+}
+
+func TestParsePortRanges(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []PortRange
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"80", []PortRange{{80, 80}}, false},
+		{"80, 443,8000-8100", []PortRange{{80, 80}, {443, 443}, {8000, 8100}}, false},
+		{"0-65535", []PortRange{{0, 65535}}, false},
+		{"8100-8000", nil, true},
+		{"65536", nil, true},
+		{"http", nil, true},
+		{"80-", nil, true},
+		{"-80", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortRanges(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePortRanges(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseProtocols(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []utils.IPProto
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"tcp", []utils.IPProto{utils.IPProtoTCP}, false},
+		{"tcp, udp", []utils.IPProto{utils.IPProtoTCP, utils.IPProtoUDP}, false},
+		{"ipx", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseProtocols(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseProtocols(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultFlapWindow is the sliding window transitions are counted over.
+	DefaultFlapWindow = 10 * time.Minute
+	// DefaultFlapThreshold is the number of transitions within the window
+	// above which a real server is flapping.
+	DefaultFlapThreshold = 4
+)
+
+var (
+	rsStateTransitionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rs", "state_transitions_total"),
+		"Inhibited state transitions of a real server seen between scrapes.",
+		[]string{"vs", "rs"},
+		nil,
+	)
+	rsFlappingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rs", "flapping"),
+		"Whether the inhibited state of a real server changed more often than the threshold within the window.",
+		[]string{"vs", "rs"},
+		nil,
+	)
+)
+
+// flapState is the inhibited state history of a real server.
+type flapState struct {
+	inhibited   bool
+	transitions float64
+	recent      []time.Time // transitions within the window, oldest first
+}
+
+// FlapCollector tracks the inhibited state of the exported real servers,
+// typically toggled by health checks, and flags those whose state changed
+// more than threshold times within window. States are sampled at each
+// scrape, so a state reverted between two scrapes goes unnoticed.
+type FlapCollector struct {
+	comm      lb.Backend
+	window    time.Duration
+	threshold int
+
+	mu      sync.Mutex
+	servers map[[2]string]*flapState
+}
+
+// NewFlapCollector returns a FlapCollector, with DefaultFlapWindow and
+// DefaultFlapThreshold for a zero window or threshold.
+func NewFlapCollector(comm lb.Backend, window time.Duration, threshold int) *FlapCollector {
+	if window <= 0 {
+		window = DefaultFlapWindow
+	}
+	if threshold <= 0 {
+		threshold = DefaultFlapThreshold
+	}
+	return &FlapCollector{
+		comm:      comm,
+		window:    window,
+		threshold: threshold,
+		servers:   make(map[[2]string]*flapState),
+	}
+}
+
+func (c *FlapCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rsStateTransitionsDesc
+	ch <- rsFlappingDesc
+}
+
+func (c *FlapCollector) Update(ch chan<- prometheus.Metric) error {
+	services, err := c.comm.ListVirtualServices()
+	if err != nil || services == nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	seen := make(map[[2]string]bool)
+	for _, vss := range services.Items {
+		key := GetServiceIdentifier(&vss)
+		if _, exists := connInfo[key]; !exists || vss.RSs == nil {
+			continue
+		}
+		for _, rs := range vss.RSs.Items {
+			if rs.Spec == nil {
+				continue
+			}
+			rsKey := GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)
+			if _, exists := connInfo[rsKey]; !exists {
+				continue
+			}
+			id := [2]string{key, rsKey}
+			seen[id] = true
+			s := c.observe(id, rs.Spec.Inhibited != nil && *rs.Spec.Inhibited, now)
+			var flapping float64
+			if len(s.recent) > c.threshold {
+				flapping = 1
+			}
+			ch <- prometheus.MustNewConstMetric(rsStateTransitionsDesc, prometheus.CounterValue, s.transitions, key, rsKey)
+			ch <- prometheus.MustNewConstMetric(rsFlappingDesc, prometheus.GaugeValue, flapping, key, rsKey)
+		}
+	}
+	// Forget removed real servers, their transitions start over if added back.
+	for id := range c.servers {
+		if !seen[id] {
+			delete(c.servers, id)
+		}
+	}
+	return nil
+}
+
+// observe records the inhibited state of a real server at now.
+func (c *FlapCollector) observe(id [2]string, inhibited bool, now time.Time) *flapState {
+	s, exists := c.servers[id]
+	if !exists {
+		s = &flapState{inhibited: inhibited}
+		c.servers[id] = s
+	}
+	if s.inhibited != inhibited {
+		s.inhibited = inhibited
+		s.transitions++
+		s.recent = append(s.recent, now)
+	}
+	expired := 0
+	for expired < len(s.recent) && now.Sub(s.recent[expired]) > c.window {
+		expired++
+	}
+	s.recent = s.recent[expired:]
+	return s
+}
@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlapCollector(t *testing.T) {
+	c := NewFlapCollector(nil, time.Minute, 2)
+	id := [2]string{"10.0.0.1:80:TCP", "10.1.0.1:80:TCP"}
+	t0 := time.Unix(1000, 0)
+	steps := []struct {
+		after       time.Duration
+		inhibited   bool
+		transitions float64
+		recent      int
+	}{
+		{0, false, 0, 0},
+		{10 * time.Second, true, 1, 1},
+		{20 * time.Second, false, 2, 2},
+		{30 * time.Second, true, 3, 3}, // flapping
+		{40 * time.Second, true, 3, 3},
+		{75 * time.Second, true, 3, 2}, // the first transition left the window
+	}
+	for _, s := range steps {
+		state := c.observe(id, s.inhibited, t0.Add(s.after))
+		if state.transitions != s.transitions || len(state.recent) != s.recent {
+			t.Errorf("at %v: got %v transitions, %d recent; want %v, %d",
+				s.after, state.transitions, len(state.recent), s.transitions, s.recent)
+		}
+	}
+}
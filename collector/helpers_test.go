@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type fakeBackend struct {
+	services *lb.VsResponse
+	err      error
+	nics     []*lb.NICDeviceStats
+	nicErr   error
+}
+
+func (f *fakeBackend) ListVirtualServices() (*lb.VsResponse, error) { return f.services, f.err }
+func (f *fakeBackend) ListNicStats() ([]*lb.NICDeviceStats, error)  { return f.nics, f.nicErr }
+func (f *fakeBackend) ListNicName() ([]string, error)               { return nil, nil }
+
+// gather runs sc and returns the values of desc keyed by their labels, as
+// name=value pairs sorted by name and joined with commas.
+func gather(t *testing.T, sc subCollector, desc *prometheus.Desc) map[string]float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 256)
+	if err := sc.Update(ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	values := make(map[string]float64)
+	for m := range ch {
+		if m.Desc() != desc {
+			continue
+		}
+		var pb dto.Metric
+		m.Write(&pb)
+		var labels []string
+		for _, l := range pb.GetLabel() {
+			labels = append(labels, l.GetName()+"="+l.GetValue())
+		}
+		v := pb.GetGauge().GetValue()
+		if pb.Counter != nil {
+			v = pb.GetCounter().GetValue()
+		}
+		values[strings.Join(labels, ",")] = v
+	}
+	return values
+}
+
+func testService(addr string, port int64, rss ...lb.RealServerSpecExpand) lb.VirtualServerSpecExpand {
+	proto := int64(6)
+	return lb.VirtualServerSpecExpand{
+		Addr:  &addr,
+		Port:  &port,
+		Proto: &proto,
+		RSs:   &lb.RealServerExpandList{Items: rss},
+		Stats: &lb.ServerStats{},
+	}
+}
+
+func testServer(ip string, weight, conns int64, inhibited bool) lb.RealServerSpecExpand {
+	port := int64(80)
+	return lb.RealServerSpecExpand{
+		Spec:  &lb.RealServerSpecTiny{IP: &ip, Port: &port, Weight: &weight, Inhibited: &inhibited},
+		Stats: &lb.ServerStats{Conns: &conns, InBytes: new(int64)},
+	}
+}
+
+// fakeAgent serves replies as JSON, keyed by URL path, and 404 for any other
+// path, and returns a client of it.
+func fakeAgent(t *testing.T, replies map[string]interface{}) *lb.DpvsAgentComm {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply, ok := replies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(reply)
+	}))
+	t.Cleanup(srv.Close)
+	return lb.NewDpvsAgentComm(strings.TrimPrefix(srv.URL, "http://"))
+}
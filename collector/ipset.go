@@ -0,0 +1,44 @@
+package collector
+
+import (
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ipsetEntriesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "ipset", "entries"),
+	"Number of members of an ipset.",
+	[]string{"set", "type"},
+	nil,
+)
+
+// IpsetCollector exports the type and member count of every DPVS ipset.
+type IpsetCollector struct {
+	comm *lb.DpvsAgentComm
+}
+
+func NewIpsetCollector(comm *lb.DpvsAgentComm) *IpsetCollector {
+	return &IpsetCollector{
+		comm: comm,
+	}
+}
+
+func (c *IpsetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ipsetEntriesDesc
+}
+
+func (c *IpsetCollector) Update(ch chan<- prometheus.Metric) error {
+	sets, err := c.comm.ListIpsets()
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		var setType string
+		if set.Type != nil {
+			setType = string(*set.Type)
+		}
+		ch <- prometheus.MustNewConstMetric(ipsetEntriesDesc, prometheus.GaugeValue, float64(len(set.Entries)), safeDereference(set.Name), setType)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestIpsetCollector(t *testing.T) {
+	str := func(s string) *string { return &s }
+	hashNet, hashIP := lb.HashNet, lb.HashIP
+	c := NewIpsetCollector(fakeAgent(t, map[string]interface{}{
+		"/v2/ipset": lb.IpsetInfoArray{Infos: []lb.IpsetInfo{
+			{Name: str("blocked"), Type: &hashNet, Entries: []lb.IpsetMember{
+				{Entry: str("10.0.0.0/8")}, {Entry: str("192.168.0.0/16"), Comment: str("lab")},
+			}},
+			{Name: str("empty"), Type: &hashIP},
+			{Name: str("untyped"), Entries: []lb.IpsetMember{{Entry: str("10.0.0.1")}}},
+		}},
+	}))
+
+	want := map[string]float64{
+		"set=blocked,type=hash:net": 2,
+		"set=empty,type=hash:ip":    0,
+		"set=untyped,type=":         1,
+	}
+	if got := gather(t, c, ipsetEntriesDesc); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	c = NewIpsetCollector(fakeAgent(t, nil))
+	if err := c.Update(make(chan prometheus.Metric, 1)); err == nil {
+		t.Error("Update succeeded without the ipset endpoint")
+	}
+}
@@ -0,0 +1,246 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	laddrCountDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "laddr", "count"),
+		"Number of FNAT local addresses configured for a virtual service.",
+		[]string{"vs"},
+		nil,
+	)
+	laddrInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "laddr", "info"),
+		"FNAT local address of a virtual service.",
+		[]string{"vs", "laddr", "device"},
+		nil,
+	)
+	laddrConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "laddr", "conns"),
+		"Connections using an FNAT local address.",
+		[]string{"vs", "laddr"},
+		nil,
+	)
+	laddrPortConflictDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "laddr", "port_conflicts_total"),
+		"Source port conflicts on an FNAT local address.",
+		[]string{"vs", "laddr"},
+		nil,
+	)
+	fnatPortUtilizationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "fnat", "port_utilization_ratio"),
+		"Estimated share of the FNAT source ports toward a real server in use.",
+		[]string{"vs", "rs"},
+		nil,
+	)
+)
+
+// DefaultFNATPorts is the source port range DPVS allocates FNAT connections from.
+var DefaultFNATPorts = PortRange{Min: 1025, Max: 65535}
+
+// LaddrCollector exports the FNAT local address pool of every exported
+// virtual service with FNAT real servers, and estimates how much of the
+// source port space toward each real server is in use. The estimate is an RS
+// level series: it is only exported for the real servers selected by the
+// ConnFilter, so not at all with VSAggregates.
+type LaddrCollector struct {
+	services    lb.Backend
+	comm        *lb.DpvsAgentComm
+	ports       PortRange
+	concurrency int
+
+	mu     sync.Mutex
+	conns  map[[2]string]int64           // RS Conns of the previous scrape, by VS and RS
+	shares map[string]map[string]float64 // RS shares of the VS connections, by VS
+}
+
+// NewLaddrCollector returns a LaddrCollector listing services from services
+// and querying the local addresses of up to concurrency of them at once,
+// DefaultConcurrency if zero. It assumes ports are usable as FNAT source
+// ports, DefaultFNATPorts if ports is empty.
+func NewLaddrCollector(services lb.Backend, comm *lb.DpvsAgentComm, ports PortRange, concurrency int) *LaddrCollector {
+	if ports.Max == 0 {
+		ports = DefaultFNATPorts
+	}
+	return &LaddrCollector{
+		services:    services,
+		comm:        comm,
+		ports:       ports,
+		concurrency: concurrency,
+		conns:       make(map[[2]string]int64),
+		shares:      make(map[string]map[string]float64),
+	}
+}
+
+func (c *LaddrCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- laddrCountDesc
+	ch <- laddrInfoDesc
+	ch <- laddrConnsDesc
+	ch <- laddrPortConflictDesc
+	ch <- fnatPortUtilizationDesc
+}
+
+func (c *LaddrCollector) Update(ch chan<- prometheus.Metric) error {
+	_, err := c.UpdateLimited(ch, 0)
+	return err
+}
+
+// UpdateLimited keeps the FNAT services carrying the most traffic, with the
+// series of all their local addresses, when they exceed maxSeries.
+func (c *LaddrCollector) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
+	services, err := c.services.ListVirtualServices()
+	if err != nil || services == nil {
+		return 0, err
+	}
+	var fnat []*lb.VirtualServerSpecExpand
+	for i := range services.Items {
+		vss := &services.Items[i]
+		if _, exists := connInfo[GetServiceIdentifier(vss)]; exists && lb.VipPort(vss) != "" && isFNAT(vss) {
+			fnat = append(fnat, vss)
+		}
+	}
+	laddrs := make([][]lb.LocalAddressSpecExpand, len(fnat))
+	err = fanout(len(fnat), c.concurrency, func(i int) error {
+		var err error
+		if laddrs[i], err = c.comm.ListLocalAddresses(lb.VipPort(fnat[i])); err != nil {
+			return fmt.Errorf("%s: %w", GetServiceIdentifier(fnat[i]), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := make(map[[2]string]int64)
+	shares := make(map[string]map[string]float64)
+	entries := make([]rankedEntry, len(fnat))
+	for i, vss := range fnat {
+		key := GetServiceIdentifier(vss)
+		e := &entries[i]
+		e.weight = statsTraffic(vss.Stats)
+		e.metrics = append(e.metrics, prometheus.MustNewConstMetric(laddrCountDesc, prometheus.GaugeValue, float64(len(laddrs[i])), key))
+		var active int64
+		for _, laddr := range laddrs[i] {
+			active += safeDereferenceInt64(laddr.Conns)
+			addr := canonicalIP(safeDereference(laddr.Addr))
+			e.metrics = append(e.metrics,
+				prometheus.MustNewConstMetric(laddrInfoDesc, prometheus.GaugeValue, 1, key, addr, safeDereference(laddr.Device)),
+				prometheus.MustNewConstMetric(laddrConnsDesc, prometheus.GaugeValue, float64(safeDereferenceInt64(laddr.Conns)), key, addr),
+				prometheus.MustNewConstMetric(laddrPortConflictDesc, prometheus.CounterValue, float64(safeDereferenceInt64(laddr.PortConflict)), key, addr),
+			)
+		}
+		for rsKey, ratio := range c.portUtilization(vss, key, len(laddrs[i]), active, conns, shares) {
+			e.metrics = append(e.metrics, prometheus.MustNewConstMetric(fnatPortUtilizationDesc, prometheus.GaugeValue, ratio, key, rsKey))
+		}
+	}
+	c.conns, c.shares = conns, shares
+	return emitRanked(ch, entries, maxSeries), nil
+}
+
+// portUtilization estimates the port utilization of each exported real
+// server of vss, recording their Conns in conns and their shares in shares.
+// The connections held on the local addresses are apportioned to the real
+// servers by their share of the connections made since the previous scrape,
+// or of their current CPS on the first scrape and after a counter reset;
+// each of them may use every local address with every port in the usable
+// range. Without recent connections, long-lived ones keep the shares of the
+// previous scrape, or are apportioned by weight when the real servers
+// changed. Nothing
+// is estimated for a service without local addresses.
+func (c *LaddrCollector) portUtilization(vss *lb.VirtualServerSpecExpand, key string, laddrs int, active int64, conns map[[2]string]int64, shares map[string]map[string]float64) map[string]float64 {
+	type server struct {
+		key                 string
+		delta, rate, weight int64
+	}
+	var (
+		servers []server
+		reset   bool
+	)
+	for _, rs := range vss.RSs.Items {
+		if rs.Spec == nil {
+			continue
+		}
+		stats := rs.Stats
+		if stats == nil {
+			stats = &lb.ServerStats{}
+		}
+		rsKey := GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)
+		id := [2]string{key, rsKey}
+		now := safeDereferenceInt64(stats.Conns)
+		conns[id] = now
+		prev, seen := c.conns[id]
+		reset = reset || !seen || now < prev
+		servers = append(servers, server{rsKey, now - prev, safeDereferenceInt64(stats.CPS), safeDereferenceInt64(rs.Spec.Weight)})
+	}
+	capacity := float64(laddrs) * float64(c.ports.Max-c.ports.Min+1)
+	if capacity == 0 || len(servers) == 0 {
+		return nil
+	}
+
+	share := make(map[string]float64)
+	apportion := func(part func(server) int64) bool {
+		var total int64
+		for _, s := range servers {
+			total += part(s)
+		}
+		if total <= 0 {
+			return false
+		}
+		for _, s := range servers {
+			share[s.key] = float64(part(s)) / float64(total)
+		}
+		return true
+	}
+	recent := func(s server) int64 {
+		if reset {
+			return s.rate
+		}
+		return s.delta
+	}
+	covered := func(prev map[string]float64) bool {
+		for _, s := range servers {
+			if _, ok := prev[s.key]; !ok {
+				return false
+			}
+		}
+		return len(prev) > 0
+	}
+	if !apportion(recent) {
+		if prev := c.shares[key]; covered(prev) {
+			share = prev
+		} else if !apportion(func(s server) int64 { return s.weight }) {
+			apportion(func(server) int64 { return 1 })
+		}
+	}
+	shares[key] = share
+
+	ratios := make(map[string]float64)
+	for _, s := range servers {
+		if _, exists := connInfo[s.key]; exists {
+			ratios[s.key] = float64(active) * share[s.key] / capacity
+		}
+	}
+	return ratios
+}
+
+// isFNAT reports whether any real server of vss is forwarded in FNAT mode.
+func isFNAT(vss *lb.VirtualServerSpecExpand) bool {
+	if vss.RSs == nil {
+		return false
+	}
+	for _, rs := range vss.RSs.Items {
+		if rs.Spec != nil && rs.Spec.Mode != nil && *rs.Spec.Mode == lb.Fnat {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"testing"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func fnatService(addr string, rss ...lb.RealServerSpecExpand) lb.VirtualServerSpecExpand {
+	fnat := lb.Fnat
+	for _, rs := range rss {
+		rs.Spec.Mode = &fnat
+	}
+	return testService(addr, 80, rss...)
+}
+
+func TestLaddrCollector(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int64) *int64 { return &n }
+	agent := fakeAgent(t, map[string]interface{}{
+		"/v2/vs/10.0.0.1-80-tcp/laddr": lb.LocalAddressExpandList{Items: []lb.LocalAddressSpecExpand{
+			{Addr: str("192.168.0.1"), Device: str("dpdk0"), Conns: num(600), PortConflict: num(2)},
+			{Addr: str("192.168.0.2"), Device: str("dpdk0"), Conns: num(400)},
+		}},
+		"/v2/vs/10.0.0.2-80-tcp/laddr": lb.LocalAddressExpandList{},
+	})
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		fnatService("10.0.0.1", testServer("10.1.0.1", 1, 100, false), testServer("10.1.0.2", 1, 900, false)),
+		fnatService("10.0.0.2", testServer("10.1.0.3", 1, 0, false)),
+	}}}
+	rss := backend.services.Items[0].RSs.Items
+	rss[0].Stats.CPS, rss[1].Stats.CPS = num(30), num(10)
+	InitConnStatsController(backend.services.Items, nil)
+	// 2 local addresses with 1000 ports each.
+	c := NewLaddrCollector(backend, agent, PortRange{Min: 1001, Max: 2000}, 1)
+
+	const vs = "vs=10.0.0.1:80:TCP"
+	if got := gather(t, c, laddrCountDesc); got[vs] != 2 || got["vs=10.0.0.2:80:TCP"] != 0 {
+		t.Errorf("unexpected local address counts %v", got)
+	}
+	if got := gather(t, c, laddrConnsDesc)["laddr=192.168.0.1,"+vs]; got != 600 {
+		t.Errorf("got %v local address connections, want 600", got)
+	}
+
+	// The first scrape apportions the 1000 connections by CPS; a fresh
+	// collector is used since gather above already scraped twice.
+	c = NewLaddrCollector(backend, agent, PortRange{Min: 1001, Max: 2000}, 1)
+	util := gather(t, c, fnatPortUtilizationDesc)
+	if got := util["rs=10.1.0.1:80:TCP,"+vs]; got != 0.375 {
+		t.Errorf("got utilization %v by CPS, want 0.375", got)
+	}
+	if _, exists := util["rs=10.1.0.3:80:TCP,vs=10.0.0.2:80:TCP"]; exists {
+		t.Error("got a utilization without local addresses")
+	}
+
+	// Later scrapes apportion them by the connections made since, however
+	// busy the real servers were before.
+	*rss[0].Stats.Conns, *rss[1].Stats.Conns = 190, 910
+	util = gather(t, c, fnatPortUtilizationDesc)
+	if got := util["rs=10.1.0.1:80:TCP,"+vs]; got != 0.45 {
+		t.Errorf("got utilization %v by recent connections, want 0.45", got)
+	}
+	// Without recent connections, the long-lived ones keep their shares.
+	*rss[0].Stats.Conns, *rss[1].Stats.Conns = 190, 910
+	util = gather(t, c, fnatPortUtilizationDesc)
+	if got := util["rs=10.1.0.1:80:TCP,"+vs]; got != 0.45 {
+		t.Errorf("got utilization %v without recent connections, want 0.45", got)
+	}
+
+	// Or are apportioned by weight, without previous shares.
+	rss[0].Spec.Weight, rss[1].Spec.Weight = num(3), num(1)
+	rss[0].Stats.CPS, rss[1].Stats.CPS = num(0), num(0)
+	c = NewLaddrCollector(backend, agent, PortRange{Min: 1001, Max: 2000}, 1)
+	util = gather(t, c, fnatPortUtilizationDesc)
+	if got := util["rs=10.1.0.1:80:TCP,"+vs]; got != 0.375 {
+		t.Errorf("got utilization %v by weight, want 0.375", got)
+	}
+
+	agent = fakeAgent(t, nil)
+	if err := NewLaddrCollector(backend, agent, PortRange{}, 0).Update(make(chan prometheus.Metric, 64)); err == nil {
+		t.Error("expected an error from the agent")
+	}
+}
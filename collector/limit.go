@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// limitedCollector is implemented by the collectors whose series scale with
+// the number of services, real servers or NICs. UpdateLimited exports at most
+// maxSeries series, all of them if non-positive, and returns the number of
+// series dropped.
+type limitedCollector interface {
+	subCollector
+	UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (dropped int, err error)
+}
+
+// rankedEntry holds the series of an entry of a limited collector, e.g. a
+// service and its labels or a NIC, weighted by the traffic it carries.
+type rankedEntry struct {
+	weight  float64
+	metrics []prometheus.Metric
+}
+
+// emitRanked sends the series of entries to ch, heaviest entry first. Entries
+// are kept or dropped whole: once an entry doesn't fit within maxSeries, it
+// and every lighter one are dropped. It returns the number of series dropped.
+func emitRanked(ch chan<- prometheus.Metric, entries []rankedEntry, maxSeries int) (dropped int) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].weight > entries[j].weight
+	})
+	var emitted int
+	for _, e := range entries {
+		if dropped > 0 || (maxSeries > 0 && emitted+len(e.metrics) > maxSeries) {
+			dropped += len(e.metrics)
+			continue
+		}
+		for _, m := range e.metrics {
+			ch <- m
+		}
+		emitted += len(e.metrics)
+	}
+	return dropped
+}
+
+// ParseSeriesLimits parses a comma separated list of collector=limit pairs,
+// e.g. "conn=10000,share=2000", into Options.MaxSeries.
+func ParseSeriesLimits(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, item := range splitList(s) {
+		name, value, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid series limit %q", item)
+		}
+		if !slices.Contains(LimitedCollectors, name) {
+			return nil, fmt.Errorf("collector %q can't be limited", name)
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid series limit %q", item)
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,213 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	vsMetadataDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "metadata"),
+		"Service metadata of a virtual service from the mapping file.",
+		[]string{"vs", "service", "team", "env"},
+		nil,
+	)
+	rsMetadataDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rs", "metadata"),
+		"Host metadata of a real server IP from the mapping file.",
+		[]string{"ip", "hostname"},
+		nil,
+	)
+)
+
+// ServiceMetadata describes who owns a virtual service.
+type ServiceMetadata struct {
+	Service string
+	Team    string
+	Env     string
+}
+
+// Metadata maps service identifiers and RS IPs to human readable metadata.
+type Metadata struct {
+	Services map[string]ServiceMetadata // keyed by GetServerIdentifier
+	Hosts    map[string]string          // RS IP -> hostname
+}
+
+// MetadataCollector exports the mapping file as info metrics. The file is
+// re-read whenever its modification time changes; if a reload fails the last
+// good mapping is kept and the failure is reported for that scrape.
+type MetadataCollector struct {
+	path string
+
+	mu      sync.Mutex
+	md      *Metadata
+	modTime time.Time
+}
+
+func NewMetadataCollector(path string) *MetadataCollector {
+	return &MetadataCollector{
+		path: path,
+	}
+}
+
+func (c *MetadataCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vsMetadataDesc
+	ch <- rsMetadataDesc
+}
+
+func (c *MetadataCollector) Update(ch chan<- prometheus.Metric) error {
+	md, err := c.load()
+	if md != nil {
+		for key, svc := range md.Services {
+			ch <- prometheus.MustNewConstMetric(vsMetadataDesc, prometheus.GaugeValue, 1, key, svc.Service, svc.Team, svc.Env)
+		}
+		for ip, hostname := range md.Hosts {
+			ch <- prometheus.MustNewConstMetric(rsMetadataDesc, prometheus.GaugeValue, 1, ip, hostname)
+		}
+	}
+	return err
+}
+
+func (c *MetadataCollector) load() (*Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return c.md, err
+	}
+	if c.md != nil && fi.ModTime().Equal(c.modTime) {
+		return c.md, nil
+	}
+	md, err := LoadMetadata(c.path)
+	if err != nil {
+		return c.md, fmt.Errorf("%s: %v", c.path, err)
+	}
+	c.md, c.modTime = md, fi.ModTime()
+	return md, nil
+}
+
+// metadataFile is the layout of a mapping file.
+type metadataFile struct {
+	Services    []metadataService `yaml:"services"`
+	RealServers []metadataHost    `yaml:"real_servers"`
+}
+
+type metadataService struct {
+	VIP     string `yaml:"vip"`
+	Port    int64  `yaml:"port"`
+	Proto   string `yaml:"proto"`
+	Service string `yaml:"service"`
+	Team    string `yaml:"team"`
+	Env     string `yaml:"env"`
+}
+
+type metadataHost struct {
+	IP       string `yaml:"ip"`
+	Hostname string `yaml:"hostname"`
+}
+
+// LoadMetadata reads a mapping file. Files ending in .csv are read as CSV with
+// a header row naming the columns vip, port, proto, service, team, env, rs_ip
+// and hostname; any other file is read as YAML of the form
+//
+//	services:
+//	  - vip: 10.0.0.1
+//	    port: 80
+//	    proto: tcp
+//	    service: web
+//	    team: infra
+//	    env: prod
+//	real_servers:
+//	  - ip: 192.168.1.10
+//	    hostname: web-01
+func LoadMetadata(path string) (*Metadata, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file metadataFile
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		if file, err = readCSVMetadata(bytes.NewReader(content)); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{
+		Services: make(map[string]ServiceMetadata, len(file.Services)),
+		Hosts:    make(map[string]string, len(file.RealServers)),
+	}
+	for _, svc := range file.Services {
+		protos, err := ParseProtocols(svc.Proto)
+		if err != nil || len(protos) != 1 {
+			return nil, fmt.Errorf("service %s: invalid proto %q", svc.VIP, svc.Proto)
+		}
+		proto := int64(protos[0])
+		md.Services[GetServerIdentifier(&svc.VIP, &svc.Port, &proto)] = ServiceMetadata{
+			Service: svc.Service,
+			Team:    svc.Team,
+			Env:     svc.Env,
+		}
+	}
+	for _, host := range file.RealServers {
+		if host.IP == "" {
+			return nil, fmt.Errorf("real server %q: missing ip", host.Hostname)
+		}
+		md.Hosts[canonicalIP(host.IP)] = host.Hostname
+	}
+	return md, nil
+}
+
+// readCSVMetadata reads a CSV mapping file, where a row may describe a
+// service, a real server or both.
+func readCSVMetadata(r io.Reader) (metadataFile, error) {
+	var file metadataFile
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil || len(rows) == 0 {
+		return file, err
+	}
+	header := rows[0]
+	for _, row := range rows[1:] {
+		record := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(row) {
+				record[strings.TrimSpace(col)] = strings.TrimSpace(row[i])
+			}
+		}
+		if vip := record["vip"]; vip != "" {
+			port, err := strconv.ParseInt(record["port"], 10, 64)
+			if err != nil {
+				return file, fmt.Errorf("service %s: invalid port %q", vip, record["port"])
+			}
+			file.Services = append(file.Services, metadataService{
+				VIP:     vip,
+				Port:    port,
+				Proto:   record["proto"],
+				Service: record["service"],
+				Team:    record["team"],
+				Env:     record["env"],
+			})
+		}
+		if ip := record["rs_ip"]; ip != "" {
+			file.RealServers = append(file.RealServers, metadataHost{IP: ip, Hostname: record["hostname"]})
+		}
+	}
+	return file, nil
+}
@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadMetadata(t *testing.T) {
+	want := &Metadata{
+		Services: map[string]ServiceMetadata{
+			"10.0.0.1:80:TCP": {Service: "web", Team: "infra", Env: "prod"},
+			"10.0.0.1:53:UDP": {Service: "dns", Team: "net", Env: "prod"},
+		},
+		Hosts: map[string]string{
+			"192.168.1.10": "web-01",
+		},
+	}
+	files := map[string]string{
+		"mapping.yaml": `# service ownership
+services:
+  - vip: 10.0.0.1
+    port: 80
+    proto: tcp
+    service: web
+    team: infra
+    env: prod
+  - vip: "10.0.0.1"
+    port: 53
+    proto: udp
+    service: dns # resolver
+    team: net
+    env: prod
+real_servers:
+  - ip: 192.168.1.10
+    hostname: web-01
+`,
+		"mapping.csv": `vip,port,proto,service,team,env,rs_ip,hostname
+10.0.0.1,80,tcp,web,infra,prod,,
+10.0.0.1,53,udp,dns,net,prod,,
+,,,,,,192.168.1.10,web-01
+`,
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadMetadata(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestLoadMetadataYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.yml")
+	content := `defaults: &infra
+  team: infra
+  env: prod
+services:
+  - {vip: 10.0.0.1, port: 80, proto: tcp, service: web, team: infra, env: prod}
+  - <<: *infra
+    vip: 2001:DB8::1
+    port: 443
+    proto: tcp
+    service: >-
+      web
+      tls
+real_servers:
+  - {ip: "2001:db8:0::10", hostname: web-02}
+  - ip: ::ffff:192.168.1.10
+    hostname: |-
+      web-01
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Metadata{
+		Services: map[string]ServiceMetadata{
+			"10.0.0.1:80:TCP":       {Service: "web", Team: "infra", Env: "prod"},
+			"[2001:db8::1]:443:TCP": {Service: "web tls", Team: "infra", Env: "prod"},
+		},
+		Hosts: map[string]string{
+			"2001:db8::10": "web-02",
+			"192.168.1.10": "web-01",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, content := range []string{
+		"services:\n  - {vip: 10.0.0.1, port: http, proto: tcp}\n",
+		"services:\n  - {vip: 10.0.0.1, port: 80, proto: ipx}\n",
+		"real_servers:\n  - {hostname: web-01}\n",
+		"services: [\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMetadata(path); err == nil {
+			t.Errorf("LoadMetadata(%q) succeeded", content)
+		}
+	}
+}
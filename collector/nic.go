@@ -1,8 +1,6 @@
 package collector
 
 import (
-	"fmt"
-
 	"dpvs_exporter/lb"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,10 +27,10 @@ type Snap struct {
 var nics map[string]*Snap
 
 type NicRateCollector struct {
-	comm *lb.DpvsAgentComm
+	comm lb.Backend
 }
 
-func NewNicRateCollector(comm *lb.DpvsAgentComm) *NicRateCollector {
+func NewNicRateCollector(comm lb.Backend) *NicRateCollector {
 	return &NicRateCollector{
 		comm: comm,
 	}
@@ -83,12 +81,12 @@ type NicStats struct {
 	InErrors  int64
 }
 
-func (c *NicRateCollector) getNicStats() []NicStats {
+func (c *NicRateCollector) getNicStats() ([]NicStats, error) {
 	nicStats, err := c.comm.ListNicStats()
-	stats := make([]NicStats, 0, len(nicStats))
 	if err != nil {
-		return stats
+		return nil, err
 	}
+	stats := make([]NicStats, 0, len(nicStats))
 	for _, nic := range nicStats {
 		stats = append(stats, NicStats{
 			Name:      safeDereference(nic.Name),
@@ -102,35 +100,49 @@ func (c *NicRateCollector) getNicStats() []NicStats {
 		})
 	}
 
-	return stats
+	return stats, nil
 }
 
-func (c *NicRateCollector) Collect(ch chan<- prometheus.Metric) {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("[panic] NicRateCollector: %v", r)
-		}
-	}()
-	nicStats := c.getNicStats()
+func (c *NicRateCollector) Update(ch chan<- prometheus.Metric) error {
+	_, err := c.UpdateLimited(ch, 0)
+	return err
+}
 
+// UpdateLimited keeps the NICs carrying the most traffic, with all their
+// series, when they exceed maxSeries.
+func (c *NicRateCollector) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
+	nicStats, err := c.getNicStats()
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []rankedEntry
 	for _, stat := range nicStats {
 		nic, exists := nics[stat.Name]
 		if exists {
-			ch <- prometheus.MustNewConstMetric(nic.buffAvail, prometheus.CounterValue, float64(stat.BuffAvail), stat.Name)
-			ch <- prometheus.MustNewConstMetric(nic.buffInUse, prometheus.CounterValue, float64(stat.BuffInUse), stat.Name)
-			ch <- prometheus.MustNewConstMetric(nic.inBytes, prometheus.CounterValue, float64(stat.InBytes), stat.Name)
-			ch <- prometheus.MustNewConstMetric(nic.inPkts, prometheus.CounterValue, float64(stat.InPkts), stat.Name)
-			ch <- prometheus.MustNewConstMetric(nic.outBytes, prometheus.CounterValue, float64(stat.OutBytes), stat.Name)
-			ch <- prometheus.MustNewConstMetric(nic.outPkts, prometheus.CounterValue, float64(stat.OutPkts), stat.Name)
-			ch <- prometheus.MustNewConstMetric(nic.inErrors, prometheus.CounterValue, float64(stat.InErrors), stat.Name)
+			entries = append(entries, rankedEntry{float64(stat.InBytes + stat.OutBytes), []prometheus.Metric{
+				prometheus.MustNewConstMetric(nic.buffAvail, prometheus.CounterValue, float64(stat.BuffAvail), stat.Name),
+				prometheus.MustNewConstMetric(nic.buffInUse, prometheus.CounterValue, float64(stat.BuffInUse), stat.Name),
+				prometheus.MustNewConstMetric(nic.inBytes, prometheus.CounterValue, float64(stat.InBytes), stat.Name),
+				prometheus.MustNewConstMetric(nic.inPkts, prometheus.CounterValue, float64(stat.InPkts), stat.Name),
+				prometheus.MustNewConstMetric(nic.outBytes, prometheus.CounterValue, float64(stat.OutBytes), stat.Name),
+				prometheus.MustNewConstMetric(nic.outPkts, prometheus.CounterValue, float64(stat.OutPkts), stat.Name),
+				prometheus.MustNewConstMetric(nic.inErrors, prometheus.CounterValue, float64(stat.InErrors), stat.Name),
+			}})
 		}
 
 	}
+	return emitRanked(ch, entries, maxSeries), nil
 }
 
-func InitNicCollector(nicName []string) {
+// InitNicCollector builds the snaps of the NICs selected by filter; a nil
+// filter selects all of them.
+func InitNicCollector(nicName []string, filter *NicFilter) {
 	nics = make(map[string]*Snap, 0)
 	for _, name := range nicName {
+		if !filter.match(name) {
+			continue
+		}
 		value := &Snap{
 			buffAvail: prometheus.NewDesc(
 				prometheus.BuildFQName(namespace, subsystem, name+"_buff_available"),
@@ -0,0 +1,211 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Scopes of dpvs_counter_resets_total.
+const (
+	resetScopeDataplane = "dataplane"
+	resetScopeVS        = "vs"
+	resetScopeRS        = "rs"
+)
+
+var (
+	counterResetsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "counter_resets_total"),
+		"Counter resets detected between scrapes: dataplane restarts, and resets of single services or real servers, e.g. removed and added again.",
+		[]string{"scope"},
+		nil,
+	)
+	dataplaneStartTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "dataplane", "start_time_seconds"),
+		"Estimated start time of the dataplane since unix epoch in seconds.",
+		nil,
+		nil,
+	)
+)
+
+// counterSnapshot holds the counters of one scrape.
+type counterSnapshot struct {
+	at       time.Time
+	services map[string][5]int64
+	servers  map[string][5]int64 // keyed by service and real server
+	nics     map[string]int64    // incoming packets
+	packets  int64               // incoming packets of all NICs
+}
+
+func statsCounters(stats *lb.ServerStats) [5]int64 {
+	if stats == nil {
+		return [5]int64{}
+	}
+	return [5]int64{
+		safeDereferenceInt64(stats.Conns),
+		safeDereferenceInt64(stats.InBytes),
+		safeDereferenceInt64(stats.OutBytes),
+		safeDereferenceInt64(stats.InPkts),
+		safeDereferenceInt64(stats.OutPkts),
+	}
+}
+
+func decreased(prev, cur [5]int64) bool {
+	for i := range cur {
+		if cur[i] < prev[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetCollector detects counter resets by comparing the counters of
+// successive scrapes. The dataplane is deemed restarted when the packet
+// counter of a NIC went backwards or, when no NIC counters are read, the
+// counters of every service did; resets of single services and real servers
+// are counted apart.
+//
+// The start time of the dataplane is estimated once the incoming packet rate
+// is known, as the time it took to receive all counted packets at that rate,
+// and only estimated again after a restart so that it doesn't jitter.
+type ResetCollector struct {
+	comm lb.Backend
+
+	mu     sync.Mutex
+	prev   *counterSnapshot
+	rate   float64 // incoming packets per second
+	resets map[string]float64
+	start  time.Time
+}
+
+func NewResetCollector(comm lb.Backend) *ResetCollector {
+	return &ResetCollector{
+		comm: comm,
+		resets: map[string]float64{
+			resetScopeDataplane: 0,
+			resetScopeVS:        0,
+			resetScopeRS:        0,
+		},
+	}
+}
+
+func (c *ResetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- counterResetsDesc
+	ch <- dataplaneStartTimeDesc
+}
+
+func (c *ResetCollector) Update(ch chan<- prometheus.Metric) error {
+	services, err := c.comm.ListVirtualServices()
+	if err != nil {
+		return err
+	}
+	// Agents not serving the NIC endpoints leave the service counters as the
+	// only restart signal.
+	nicStats, err := c.comm.ListNicStats()
+	if err != nil && !lb.IsUnsupported(err) {
+		return err
+	}
+	cur := &counterSnapshot{
+		at:       time.Now(),
+		services: make(map[string][5]int64),
+		servers:  make(map[string][5]int64),
+		nics:     make(map[string]int64),
+	}
+	if services != nil {
+		for _, vss := range services.Items {
+			key := GetServiceIdentifier(&vss)
+			cur.services[key] = statsCounters(vss.Stats)
+			if vss.RSs == nil {
+				continue
+			}
+			for _, rs := range vss.RSs.Items {
+				if rs.Spec != nil {
+					cur.servers[key+"/"+GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)] = statsCounters(rs.Stats)
+				}
+			}
+		}
+	}
+	for _, nic := range nicStats {
+		if nic == nil || nic.Name == nil {
+			continue
+		}
+		cur.nics[*nic.Name] = safeDereferenceInt64(nic.InPkts)
+		cur.packets += safeDereferenceInt64(nic.InPkts)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observe(cur)
+	for scope, n := range c.resets {
+		ch <- prometheus.MustNewConstMetric(counterResetsDesc, prometheus.CounterValue, n, scope)
+	}
+	if !c.start.IsZero() {
+		ch <- prometheus.MustNewConstMetric(dataplaneStartTimeDesc, prometheus.GaugeValue, float64(c.start.UnixNano())/1e9)
+	}
+	return nil
+}
+
+// observe compares cur with the previous snapshot and makes it the new one.
+func (c *ResetCollector) observe(cur *counterSnapshot) {
+	prev := c.prev
+	c.prev = cur
+	if prev == nil {
+		return
+	}
+
+	restarted := false
+	var nics int
+	for name, pkts := range cur.nics {
+		if old, ok := prev.nics[name]; ok {
+			nics++
+			if pkts < old {
+				restarted = true
+			}
+		}
+	}
+	var common, vsResets int
+	for key, counters := range cur.services {
+		if old, ok := prev.services[key]; ok {
+			common++
+			if decreased(old, counters) {
+				vsResets++
+			}
+		}
+	}
+	// Without NIC counters, a reset of every service is the only sign of a
+	// restart; with them, it is the re-adding of those services.
+	if nics == 0 {
+		restarted = common > 0 && vsResets == common
+	}
+
+	elapsed := cur.at.Sub(prev.at)
+	if restarted {
+		c.resets[resetScopeDataplane]++
+		// Started between the two scrapes, later if the packets counted
+		// since then came in quickly at the rate seen before.
+		c.start = prev.at
+		if c.rate > 0 {
+			if uptime := time.Duration(float64(cur.packets) / c.rate * float64(time.Second)); uptime < elapsed {
+				c.start = cur.at.Add(-uptime)
+			}
+		}
+		c.rate = 0
+		return
+	}
+
+	c.resets[resetScopeVS] += float64(vsResets)
+	for key, counters := range cur.servers {
+		if old, ok := prev.servers[key]; ok && decreased(old, counters) {
+			c.resets[resetScopeRS]++
+		}
+	}
+	if elapsed > 0 && cur.packets > prev.packets {
+		c.rate = float64(cur.packets-prev.packets) / elapsed.Seconds()
+		if c.start.IsZero() {
+			c.start = cur.at.Add(-time.Duration(float64(cur.packets) / c.rate * float64(time.Second)))
+		}
+	}
+}
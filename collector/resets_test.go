@@ -0,0 +1,100 @@
+package collector
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestResetCollector(t *testing.T) {
+	c := NewResetCollector(nil)
+	t0 := time.Unix(1000, 0)
+	snapshot := func(at time.Duration, packets int64, vs, rs int64) *counterSnapshot {
+		return &counterSnapshot{
+			at:       t0.Add(at),
+			services: map[string][5]int64{"a": {vs}, "b": {100}},
+			servers:  map[string][5]int64{"a/x": {rs}},
+			nics:     map[string]int64{"dpdk0": packets},
+			packets:  packets,
+		}
+	}
+
+	c.observe(snapshot(0, 1000, 10, 10))
+	c.observe(snapshot(10*time.Second, 2000, 20, 5))
+	if c.resets[resetScopeRS] != 1 || c.resets[resetScopeVS] != 0 || c.resets[resetScopeDataplane] != 0 {
+		t.Errorf("unexpected resets %v after a real server reset", c.resets)
+	}
+	// 2000 packets at 100 packets per second.
+	if want := t0.Add(-10 * time.Second); !c.start.Equal(want) {
+		t.Errorf("got start time %v, want %v", c.start, want)
+	}
+
+	c.observe(snapshot(20*time.Second, 200, 1, 1))
+	if c.resets[resetScopeDataplane] != 1 || c.resets[resetScopeRS] != 1 || c.resets[resetScopeVS] != 0 {
+		t.Errorf("unexpected resets %v after a restart", c.resets)
+	}
+	// 200 packets at 100 packets per second.
+	if want := t0.Add(18 * time.Second); !c.start.Equal(want) {
+		t.Errorf("got start time %v, want %v", c.start, want)
+	}
+}
+
+func TestResetCollectorServiceReadded(t *testing.T) {
+	c := NewResetCollector(nil)
+	t0 := time.Unix(1000, 0)
+	snapshot := func(at time.Duration, packets int64, nics map[string]int64, vs int64) *counterSnapshot {
+		return &counterSnapshot{
+			at:       t0.Add(at),
+			services: map[string][5]int64{"a": {vs}},
+			servers:  map[string][5]int64{},
+			nics:     nics,
+			packets:  packets,
+		}
+	}
+
+	// The only service is re-added while the NICs keep counting.
+	c.observe(snapshot(0, 1000, map[string]int64{"dpdk0": 1000}, 10))
+	c.observe(snapshot(10*time.Second, 2000, map[string]int64{"dpdk0": 2000}, 20))
+	start := c.start
+	c.observe(snapshot(20*time.Second, 3000, map[string]int64{"dpdk0": 3000}, 1))
+	if c.resets[resetScopeDataplane] != 0 || c.resets[resetScopeVS] != 1 {
+		t.Errorf("unexpected resets %v after a service was re-added", c.resets)
+	}
+	if !c.start.Equal(start) {
+		t.Errorf("start time moved from %v to %v", start, c.start)
+	}
+
+	// Without NIC counters, it's all there is to tell a restart.
+	c = NewResetCollector(nil)
+	c.observe(snapshot(0, 0, map[string]int64{}, 10))
+	c.observe(snapshot(10*time.Second, 0, map[string]int64{}, 1))
+	if c.resets[resetScopeDataplane] != 1 || c.resets[resetScopeVS] != 0 {
+		t.Errorf("unexpected resets %v after a restart without NIC counters", c.resets)
+	}
+}
+
+func TestResetCollectorWithoutNics(t *testing.T) {
+	backend := &fakeBackend{
+		services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{testService("10.0.0.1", 80)}},
+		nicErr:   &lb.StatusError{URL: "/v2/device/name/nic", StatusCode: http.StatusNotFound},
+	}
+	c := NewResetCollector(backend)
+	if got := gather(t, c, counterResetsDesc); len(got) != 3 {
+		t.Errorf("unexpected resets %v", got)
+	}
+
+	for _, err := range []error{
+		errors.New("connection refused"),
+		&lb.StatusError{URL: "/v2/device/name/nic", StatusCode: http.StatusServiceUnavailable},
+	} {
+		backend.nicErr = err
+		if err := c.Update(make(chan prometheus.Metric, 16)); err == nil {
+			t.Errorf("%v: expected an error from the agent", backend.nicErr)
+		}
+	}
+}
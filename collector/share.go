@@ -0,0 +1,185 @@
+package collector
+
+import (
+	"math"
+	"sync"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rsShareDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rs", "share_ratio"),
+		"Share of the connections or incoming bytes of a virtual service handled by a real server since the previous scrape.",
+		[]string{"vs", "rs", "basis"},
+		nil,
+	)
+	rsExpectedShareDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rs", "expected_share_ratio"),
+		"Share of a virtual service a real server should handle given its weight; inhibited real servers expect none.",
+		[]string{"vs", "rs"},
+		nil,
+	)
+	rsShareDeviationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rs", "share_deviation_ratio"),
+		"Share of a real server minus its expected share.",
+		[]string{"vs", "rs", "basis"},
+		nil,
+	)
+	vsImbalanceDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vs", "imbalance_index"),
+		"Half the sum of the absolute share deviations of the real servers of a virtual service, from 0 (as weighted) to 1.",
+		[]string{"vs", "basis"},
+		nil,
+	)
+)
+
+// shareBases are the counters shares are computed from.
+var shareBases = []struct {
+	name  string
+	value func(*lb.ServerStats) int64
+}{
+	{"conns", func(s *lb.ServerStats) int64 { return safeDereferenceInt64(s.Conns) }},
+	{"in_bytes", func(s *lb.ServerStats) int64 { return safeDereferenceInt64(s.InBytes) }},
+}
+
+// ShareCollector compares how the traffic of every exported virtual service
+// is spread over its real servers with how their weights say it should be.
+// Shares are computed over the counter increases since the previous scrape,
+// or over the counters themselves on the first scrape and after a reset.
+type ShareCollector struct {
+	comm lb.Backend
+
+	mu   sync.Mutex
+	last map[[2]string][]int64 // keyed by VS and RS, indexed like shareBases
+}
+
+func NewShareCollector(comm lb.Backend) *ShareCollector {
+	return &ShareCollector{
+		comm: comm,
+		last: make(map[[2]string][]int64),
+	}
+}
+
+func (c *ShareCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rsShareDesc
+	ch <- rsExpectedShareDesc
+	ch <- rsShareDeviationDesc
+	ch <- vsImbalanceDesc
+}
+
+func (c *ShareCollector) Update(ch chan<- prometheus.Metric) error {
+	_, err := c.UpdateLimited(ch, 0)
+	return err
+}
+
+// UpdateLimited keeps the services carrying the most traffic, with the
+// series of all their real servers, when they exceed maxSeries.
+func (c *ShareCollector) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
+	services, err := c.comm.ListVirtualServices()
+	if err != nil || services == nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := make(map[[2]string][]int64)
+	var entries []rankedEntry
+	for _, vss := range services.Items {
+		key := GetServiceIdentifier(&vss)
+		if _, exists := connInfo[key]; !exists || vss.RSs == nil {
+			continue
+		}
+
+		type rsTraffic struct {
+			key     string
+			weight  float64
+			traffic []int64
+		}
+		var (
+			servers     []rsTraffic
+			totalWeight float64
+			reset       bool
+		)
+		for _, rs := range vss.RSs.Items {
+			if rs.Spec == nil {
+				continue
+			}
+			s := rsTraffic{key: GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)}
+			if rs.Spec.Inhibited == nil || !*rs.Spec.Inhibited {
+				s.weight = float64(safeDereferenceInt64(rs.Spec.Weight))
+			}
+			stats := rs.Stats
+			if stats == nil {
+				stats = &lb.ServerStats{}
+			}
+			s.traffic = make([]int64, len(shareBases))
+			for i, b := range shareBases {
+				s.traffic[i] = b.value(stats)
+			}
+			id := [2]string{key, s.key}
+			last[id] = s.traffic
+			if prev, ok := c.last[id]; !ok {
+				reset = true
+			} else {
+				for i := range prev {
+					reset = reset || s.traffic[i] < prev[i]
+				}
+			}
+			servers = append(servers, s)
+			totalWeight += s.weight
+		}
+
+		totals := make([]int64, len(shareBases))
+		deltas := make([][]int64, len(servers))
+		for j, s := range servers {
+			deltas[j] = make([]int64, len(shareBases))
+			for i := range shareBases {
+				deltas[j][i] = s.traffic[i]
+				if !reset {
+					deltas[j][i] -= c.last[[2]string{key, s.key}][i]
+				}
+				totals[i] += deltas[j][i]
+			}
+		}
+
+		entry := rankedEntry{weight: statsTraffic(vss.Stats)}
+		imbalance := make([]float64, len(shareBases))
+		for j, s := range servers {
+			_, exported := connInfo[s.key]
+			expected := math.NaN()
+			if totalWeight > 0 {
+				expected = s.weight / totalWeight
+				if exported {
+					entry.metrics = append(entry.metrics, prometheus.MustNewConstMetric(rsExpectedShareDesc, prometheus.GaugeValue, expected, key, s.key))
+				}
+			}
+			for i, b := range shareBases {
+				if totals[i] == 0 {
+					continue
+				}
+				share := float64(deltas[j][i]) / float64(totals[i])
+				if exported {
+					entry.metrics = append(entry.metrics, prometheus.MustNewConstMetric(rsShareDesc, prometheus.GaugeValue, share, key, s.key, b.name))
+				}
+				if totalWeight > 0 {
+					if exported {
+						entry.metrics = append(entry.metrics, prometheus.MustNewConstMetric(rsShareDeviationDesc, prometheus.GaugeValue, share-expected, key, s.key, b.name))
+					}
+					imbalance[i] += math.Abs(share - expected)
+				}
+			}
+		}
+		if totalWeight > 0 && len(servers) > 0 {
+			for i, b := range shareBases {
+				if totals[i] > 0 {
+					entry.metrics = append(entry.metrics, prometheus.MustNewConstMetric(vsImbalanceDesc, prometheus.GaugeValue, imbalance[i]/2, key, b.name))
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	c.last = last
+	return emitRanked(ch, entries, maxSeries), nil
+}
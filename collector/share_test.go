@@ -0,0 +1,39 @@
+package collector
+
+import (
+	"math"
+	"testing"
+
+	"dpvs_exporter/lb"
+)
+
+func TestShareCollector(t *testing.T) {
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80,
+			testServer("10.1.0.1", 1, 10, false),
+			testServer("10.1.0.2", 1, 30, false),
+			testServer("10.1.0.3", 1, 0, true)),
+	}}}
+	InitConnStatsController(backend.services.Items, nil)
+	c := NewShareCollector(backend)
+
+	const vs = "vs=10.0.0.1:80:TCP"
+	share := gather(t, c, rsShareDesc)
+	if got := share["basis=conns,rs=10.1.0.2:80:TCP,"+vs]; got != 0.75 {
+		t.Errorf("got share %v, want 0.75", got)
+	}
+	if _, exists := share["basis=in_bytes,rs=10.1.0.2:80:TCP,"+vs]; exists {
+		t.Error("got a share of no traffic")
+	}
+	if got := gather(t, c, rsExpectedShareDesc)["rs=10.1.0.3:80:TCP,"+vs]; got != 0 {
+		t.Errorf("got expected share %v of an inhibited server, want 0", got)
+	}
+
+	// Only the traffic since the previous scrape counts.
+	*backend.services.Items[0].RSs.Items[0].Stats.Conns = 40
+	*backend.services.Items[0].RSs.Items[1].Stats.Conns = 60
+	imbalance := gather(t, c, vsImbalanceDesc)["basis=conns,"+vs]
+	if math.Abs(imbalance) > 1e-9 {
+		t.Errorf("got imbalance %v, want 0", imbalance)
+	}
+}
@@ -0,0 +1,223 @@
+package collector
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultEventHistory is the number of topology events served by /api/events.
+const DefaultEventHistory = 100
+
+// Types of topology events.
+const (
+	EventVSAdded          = "vs_added"
+	EventVSRemoved        = "vs_removed"
+	EventRSAdded          = "rs_added"
+	EventRSRemoved        = "rs_removed"
+	EventWeightChanged    = "weight_changed"
+	EventInhibitedChanged = "inhibited_changed"
+	EventSchedulerChanged = "scheduler_changed"
+)
+
+var eventTypes = []string{
+	EventVSAdded,
+	EventVSRemoved,
+	EventRSAdded,
+	EventRSRemoved,
+	EventWeightChanged,
+	EventInhibitedChanged,
+	EventSchedulerChanged,
+}
+
+var topologyChangesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "topology", "changes_total"),
+	"Changes of the services and real servers seen between scrapes.",
+	[]string{"type"},
+	nil,
+)
+
+// Event is a change of a service or real server. RS is empty for changes of
+// a service, Old and New are empty for additions and removals.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	VS   string    `json:"vs"`
+	RS   string    `json:"rs,omitempty"`
+	Old  string    `json:"old,omitempty"`
+	New  string    `json:"new,omitempty"`
+}
+
+// topologyService is the state of a service compared between scrapes.
+type topologyService struct {
+	scheduler string
+	servers   map[string]topologyServer
+}
+
+type topologyServer struct {
+	weight    int64
+	inhibited bool
+}
+
+// TopologyCollector diffs the services of successive scrapes, counts and
+// logs their changes, and serves the latest ones as JSON.
+type TopologyCollector struct {
+	comm lb.Backend
+	size int
+
+	mu      sync.Mutex
+	prev    map[string]topologyService
+	changes map[string]float64
+	events  []Event // oldest first, at most size
+}
+
+// NewTopologyCollector returns a TopologyCollector keeping the last size
+// events, DefaultEventHistory if zero.
+func NewTopologyCollector(comm lb.Backend, size int) *TopologyCollector {
+	if size <= 0 {
+		size = DefaultEventHistory
+	}
+	changes := make(map[string]float64)
+	for _, t := range eventTypes {
+		changes[t] = 0
+	}
+	return &TopologyCollector{
+		comm:    comm,
+		size:    size,
+		changes: changes,
+	}
+}
+
+func (c *TopologyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topologyChangesDesc
+}
+
+func (c *TopologyCollector) Update(ch chan<- prometheus.Metric) error {
+	services, err := c.comm.ListVirtualServices()
+	if err != nil || services == nil {
+		return err
+	}
+	cur := make(map[string]topologyService)
+	for _, vss := range services.Items {
+		svc := topologyService{
+			servers: make(map[string]topologyServer),
+		}
+		if vss.SchedName != nil {
+			svc.scheduler = string(*vss.SchedName)
+		}
+		if vss.RSs != nil {
+			for _, rs := range vss.RSs.Items {
+				if rs.Spec == nil {
+					continue
+				}
+				svc.servers[GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)] = topologyServer{
+					weight:    safeDereferenceInt64(rs.Spec.Weight),
+					inhibited: rs.Spec.Inhibited != nil && *rs.Spec.Inhibited,
+				}
+			}
+		}
+		cur[GetServiceIdentifier(&vss)] = svc
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prev != nil {
+		for _, e := range diffTopology(c.prev, cur, time.Now()) {
+			c.record(e)
+		}
+	}
+	c.prev = cur
+	for t, n := range c.changes {
+		ch <- prometheus.MustNewConstMetric(topologyChangesDesc, prometheus.CounterValue, n, t)
+	}
+	return nil
+}
+
+func (c *TopologyCollector) record(e Event) {
+	c.changes[e.Type]++
+	log.Printf("topology change: type=%s vs=%s rs=%s old=%s new=%s", e.Type, e.VS, e.RS, e.Old, e.New)
+	if len(c.events) == c.size {
+		c.events = c.events[1:]
+	}
+	c.events = append(c.events, e)
+}
+
+// APIPath is where the latest events are served.
+func (c *TopologyCollector) APIPath() string {
+	return "/api/events"
+}
+
+// ServeHTTP serves the latest events, newest first.
+func (c *TopologyCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	events := make([]Event, len(c.events))
+	for i, e := range c.events {
+		events[len(events)-1-i] = e
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// diffTopology returns the changes from prev to cur, in a stable order.
+func diffTopology(prev, cur map[string]topologyService, now time.Time) []Event {
+	var events []Event
+	for _, key := range sortedKeys(prev) {
+		if _, exists := cur[key]; !exists {
+			events = append(events, Event{Time: now, Type: EventVSRemoved, VS: key})
+		}
+	}
+	for _, key := range sortedKeys(cur) {
+		svc := cur[key]
+		old, exists := prev[key]
+		if !exists {
+			events = append(events, Event{Time: now, Type: EventVSAdded, VS: key})
+			for _, rs := range sortedKeys(svc.servers) {
+				events = append(events, Event{Time: now, Type: EventRSAdded, VS: key, RS: rs})
+			}
+			continue
+		}
+		if old.scheduler != svc.scheduler {
+			events = append(events, Event{Time: now, Type: EventSchedulerChanged, VS: key, Old: old.scheduler, New: svc.scheduler})
+		}
+		for _, rs := range sortedKeys(old.servers) {
+			if _, exists := svc.servers[rs]; !exists {
+				events = append(events, Event{Time: now, Type: EventRSRemoved, VS: key, RS: rs})
+			}
+		}
+		for _, rs := range sortedKeys(svc.servers) {
+			server := svc.servers[rs]
+			was, exists := old.servers[rs]
+			if !exists {
+				events = append(events, Event{Time: now, Type: EventRSAdded, VS: key, RS: rs})
+				continue
+			}
+			if was.weight != server.weight {
+				events = append(events, Event{Time: now, Type: EventWeightChanged, VS: key, RS: rs,
+					Old: strconv.FormatInt(was.weight, 10), New: strconv.FormatInt(server.weight, 10)})
+			}
+			if was.inhibited != server.inhibited {
+				events = append(events, Event{Time: now, Type: EventInhibitedChanged, VS: key, RS: rs,
+					Old: strconv.FormatBool(was.inhibited), New: strconv.FormatBool(server.inhibited)})
+			}
+		}
+	}
+	return events
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"dpvs_exporter/lb"
+)
+
+func TestTopologyCollector(t *testing.T) {
+	backend := &fakeBackend{services: &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80, testServer("10.1.0.1", 1, 0, false), testServer("10.1.0.2", 1, 0, false)),
+		testService("10.0.0.2", 80),
+	}}}
+	c := NewTopologyCollector(backend, 3)
+	gather(t, c, topologyChangesDesc)
+
+	wrr := lb.Wrr
+	backend.services = &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{
+		testService("10.0.0.1", 80, testServer("10.1.0.1", 2, 0, true), testServer("10.1.0.3", 1, 0, false)),
+		testService("10.0.0.3", 80),
+	}}
+	backend.services.Items[0].SchedName = &wrr
+	changes := gather(t, c, topologyChangesDesc)
+	for typ, want := range map[string]float64{
+		EventVSAdded:          1,
+		EventVSRemoved:        1,
+		EventRSAdded:          1,
+		EventRSRemoved:        1,
+		EventWeightChanged:    1,
+		EventInhibitedChanged: 1,
+		EventSchedulerChanged: 1,
+	} {
+		if got := changes["type="+typ]; got != want {
+			t.Errorf("got %v %s changes, want %v", got, typ, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", c.APIPath(), nil))
+	var events []Event
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want the last 3", len(events))
+	}
+	if e := events[0]; e.Type != EventVSAdded || e.VS != "10.0.0.3:80:TCP" {
+		t.Errorf("unexpected newest event %+v", e)
+	}
+	if e := events[2]; e.Type != EventInhibitedChanged || e.RS != "10.1.0.1:80:TCP" || e.Old != "false" || e.New != "true" {
+		t.Errorf("unexpected oldest event %+v", e)
+	}
+}
@@ -0,0 +1,206 @@
+package collector
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"dpvs_exporter/lb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultTopTalkers is the number of client prefixes exported per service.
+const DefaultTopTalkers = 10
+
+// Client addresses are aggregated into prefixes of these lengths.
+const (
+	talkerPrefixV4 = 24
+	talkerPrefixV6 = 64
+)
+
+var topTalkerConnsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "top_talker", "conns"),
+	"Connections from a client prefix to a virtual service, for its busiest prefixes.",
+	[]string{"vs", "prefix"},
+	nil,
+)
+
+// Talker is a client prefix and its number of connections.
+type Talker struct {
+	Prefix string `json:"prefix"`
+	Conns  int    `json:"conns"`
+}
+
+// ServiceTalkers ranks the client prefixes of a virtual service.
+type ServiceTalkers struct {
+	VS      string   `json:"vs"`
+	Talkers []Talker `json:"talkers"`
+}
+
+// TopTalkers is a ranking of the client prefixes of every exported service.
+type TopTalkers struct {
+	Time      time.Time        `json:"time"`
+	Sampled   int              `json:"sampled"`
+	Truncated bool             `json:"truncated"`
+	Services  []ServiceTalkers `json:"services"`
+}
+
+// TopTalkersCollector exports the client prefixes holding the most
+// connections to each exported virtual service, and serves the full ranking
+// of the last scrape as JSON.
+type TopTalkersCollector struct {
+	conns lb.ConnLister
+	limit int
+	top   int
+
+	mu   sync.Mutex
+	last *TopTalkers
+}
+
+// NewTopTalkersCollector returns a TopTalkersCollector reading at most limit
+// connections per scrape and exporting the top busiest prefixes of each
+// service, DefaultConnTableLimit and DefaultTopTalkers if zero.
+func NewTopTalkersCollector(conns lb.ConnLister, limit, top int) *TopTalkersCollector {
+	if limit == 0 {
+		limit = DefaultConnTableLimit
+	}
+	if top <= 0 {
+		top = DefaultTopTalkers
+	}
+	return &TopTalkersCollector{
+		conns: conns,
+		limit: limit,
+		top:   top,
+	}
+}
+
+func (c *TopTalkersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topTalkerConnsDesc
+}
+
+func (c *TopTalkersCollector) Update(ch chan<- prometheus.Metric) error {
+	_, err := c.UpdateLimited(ch, 0)
+	return err
+}
+
+// UpdateLimited keeps the services holding the most sampled connections,
+// with the series of all their top talkers, when they exceed maxSeries.
+func (c *TopTalkersCollector) UpdateLimited(ch chan<- prometheus.Metric, maxSeries int) (int, error) {
+	ranking, err := c.rank()
+	if err != nil {
+		return 0, err
+	}
+	entries := make([]rankedEntry, len(ranking.Services))
+	for j, svc := range ranking.Services {
+		for i, t := range svc.Talkers {
+			entries[j].weight += float64(t.Conns)
+			if i >= c.top {
+				continue
+			}
+			entries[j].metrics = append(entries[j].metrics, prometheus.MustNewConstMetric(topTalkerConnsDesc, prometheus.GaugeValue, float64(t.Conns), svc.VS, t.Prefix))
+		}
+	}
+	return emitRanked(ch, entries, maxSeries), nil
+}
+
+// APIPath is where the full ranking is served.
+func (c *TopTalkersCollector) APIPath() string {
+	return "/api/top-talkers"
+}
+
+// ServeHTTP serves the ranking of the last scrape, or a fresh one if there
+// was no scrape yet. A vs query parameter restricts it to one service.
+func (c *TopTalkersCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	ranking := c.last
+	c.mu.Unlock()
+	if ranking == nil {
+		var err error
+		if ranking, err = c.rank(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	if vs := r.URL.Query().Get("vs"); vs != "" {
+		filtered := *ranking
+		filtered.Services = nil
+		for _, svc := range ranking.Services {
+			if svc.VS == vs {
+				filtered.Services = append(filtered.Services, svc)
+			}
+		}
+		ranking = &filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ranking)
+}
+
+// rank reads the connection table and ranks the client prefixes of every
+// exported service.
+func (c *TopTalkersCollector) rank() (*TopTalkers, error) {
+	conns, truncated, err := c.conns.ListConns(c.limit)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]map[string]int)
+	for _, conn := range conns {
+		key := connServiceKey(&conn)
+		if _, exists := connInfo[key]; !exists {
+			continue
+		}
+		prefix := clientPrefix(conn.Client.IP)
+		if prefix == "" {
+			continue
+		}
+		if counts[key] == nil {
+			counts[key] = make(map[string]int)
+		}
+		counts[key][prefix]++
+	}
+
+	ranking := &TopTalkers{
+		Time:      time.Now(),
+		Sampled:   len(conns),
+		Truncated: truncated,
+		Services:  make([]ServiceTalkers, 0, len(counts)),
+	}
+	for key, prefixes := range counts {
+		svc := ServiceTalkers{VS: key, Talkers: make([]Talker, 0, len(prefixes))}
+		for prefix, n := range prefixes {
+			svc.Talkers = append(svc.Talkers, Talker{prefix, n})
+		}
+		sort.Slice(svc.Talkers, func(i, j int) bool {
+			if svc.Talkers[i].Conns != svc.Talkers[j].Conns {
+				return svc.Talkers[i].Conns > svc.Talkers[j].Conns
+			}
+			return svc.Talkers[i].Prefix < svc.Talkers[j].Prefix
+		})
+		ranking.Services = append(ranking.Services, svc)
+	}
+	sort.Slice(ranking.Services, func(i, j int) bool {
+		return ranking.Services[i].VS < ranking.Services[j].VS
+	})
+
+	c.mu.Lock()
+	c.last = ranking
+	c.mu.Unlock()
+	return ranking, nil
+}
+
+// clientPrefix returns the /24 or /64 prefix of addr.
+func clientPrefix(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		n := net.IPNet{IP: ip4.Mask(net.CIDRMask(talkerPrefixV4, 32)), Mask: net.CIDRMask(talkerPrefixV4, 32)}
+		return n.String()
+	}
+	n := net.IPNet{IP: ip.Mask(net.CIDRMask(talkerPrefixV6, 128)), Mask: net.CIDRMask(talkerPrefixV6, 128)}
+	return n.String()
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"dpvs_exporter/lb"
+	"dpvs_exporter/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeConns []lb.ConnEntry
+
+func (f fakeConns) ListConns(limit int) ([]lb.ConnEntry, bool, error) {
+	return f, false, nil
+}
+
+func TestClientPrefix(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.168.1.77":      "192.168.1.0/24",
+		"2001:db8:1:2:3::4": "2001:db8:1:2::/64",
+		"bogus":             "",
+	} {
+		if got := clientPrefix(addr); got != want {
+			t.Errorf("clientPrefix(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestTopTalkers(t *testing.T) {
+	vip := lb.ConnAddr{IP: "10.0.0.1", Port: 80}
+	conn := func(client string) lb.ConnEntry {
+		return lb.ConnEntry{Proto: utils.IPProtoTCP, Client: lb.ConnAddr{IP: client, Port: 40000}, Virtual: vip}
+	}
+	connInfo = map[string]*ConnectionIndicators{"10.0.0.1:80:TCP": nil}
+	c := NewTopTalkersCollector(fakeConns{
+		conn("1.1.1.1"), conn("2.2.2.2"), conn("2.2.2.3"),
+		{Proto: utils.IPProtoTCP, Client: lb.ConnAddr{IP: "3.3.3.3"}, Virtual: lb.ConnAddr{IP: "10.0.0.2", Port: 80}},
+	}, 0, 1)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", c.APIPath(), nil))
+	var ranking TopTalkers
+	if err := json.NewDecoder(rec.Body).Decode(&ranking); err != nil {
+		t.Fatal(err)
+	}
+	if ranking.Sampled != 4 || len(ranking.Services) != 1 {
+		t.Fatalf("unexpected ranking %+v", ranking)
+	}
+	want := []Talker{{"2.2.2.0/24", 2}, {"1.1.1.0/24", 1}}
+	got := ranking.Services[0].Talkers
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got talkers %+v, want %+v", got, want)
+	}
+}
+
+type countingConns struct {
+	fakeConns
+	calls int
+}
+
+func (c *countingConns) ListConns(limit int) ([]lb.ConnEntry, bool, error) {
+	c.calls++
+	return c.fakeConns.ListConns(limit)
+}
+
+func TestConnTableShared(t *testing.T) {
+	connInfo = map[string]*ConnectionIndicators{}
+	conns := &countingConns{}
+	dpvs := NewDpvs(&fakeBackend{services: &lb.VsResponse{}}, Options{
+		Enabled:   map[string]bool{"connstate": true, "toptalkers": true},
+		ConnTable: conns,
+	})
+	ch := make(chan prometheus.Metric, 1024)
+	dpvs.Collect(ch)
+	if conns.calls != 1 {
+		t.Errorf("the connection table was listed %d times in a scrape, want once", conns.calls)
+	}
+}
@@ -33,36 +33,188 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"dpvs_exporter/collector"
 	"dpvs_exporter/lb"
+	"dpvs_exporter/notify"
 )
 
 func main() {
 	var (
 		listenAddress = flag.String("web.listen-address", ":9101", "Address to listen on for web interface and telemetry.")
 		metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+		backendName   = flag.String("dpvs.backend", "agent", "Where to read the dataplane state from: agent (dpvs-agent), socket (the DPVS control socket) or command (ipvsadm and dpip output).")
+		socketPath    = flag.String("dpvs.socket", lb.DefaultSocketPath, "Path of the DPVS control socket, for the socket backend.")
+		ipvsadmPath   = flag.String("dpvs.ipvsadm-path", lb.DefaultIpvsadmPath, "Path of DPVS's ipvsadm, for the command backend and the connection table.")
+		dpipPath      = flag.String("dpvs.dpip-path", lb.DefaultDpipPath, "Path of DPVS's dpip, for the command backend and the blacklists and whitelists.")
+		timeout       = flag.Duration("collector.timeout", collector.DefaultCollectorTimeout, "Deadline for each collector within a scrape.")
+		probeInterval = flag.Duration("dpvs.agent-probe-interval", collector.DefaultProbeInterval, "Interval at which dpvs-agent is probed for the endpoints it serves, besides at startup and once it is reachable again.")
+
+		vipInclude  = flag.String("collector.conn.vip-include", "", "Comma separated VIP CIDRs to export, all if empty.")
+		vipExclude  = flag.String("collector.conn.vip-exclude", "", "Comma separated VIP CIDRs not to export.")
+		portInclude = flag.String("collector.conn.port-include", "", "Comma separated VS ports or port ranges (e.g. 80,8000-8100) to export, all if empty.")
+		portExclude = flag.String("collector.conn.port-exclude", "", "Comma separated VS ports or port ranges not to export.")
+		protocols   = flag.String("collector.conn.protocols", "", "Comma separated VS protocols (e.g. tcp,udp) to export, all if empty.")
+		rsInclude   = flag.String("collector.conn.rs-include", "", "Comma separated RS CIDRs to export, all if empty.")
+		rsExclude   = flag.String("collector.conn.rs-exclude", "", "Comma separated RS CIDRs not to export.")
+		vsOnly      = flag.Bool("collector.conn.vs-only", false, "Drop RS-level series, dpvs_fnat_port_utilization_ratio included, and only export VS aggregates.")
+		nicInclude  = flag.String("collector.nic.name-include", "", "Regexp of NIC names to export.")
+		nicExclude  = flag.String("collector.nic.name-exclude", "", "Regexp of NIC names not to export.")
+
+		maxSeries = flag.String("collector.max-series", "", "Comma separated collector=limit pairs (e.g. conn=10000,share=2000) capping the series exported by the conn, nic, share, laddr, acl, accesslist and toptalkers collectors, which keep their busiest services or NICs whole.")
+
+		concurrency  = flag.Int("collector.concurrency", collector.DefaultConcurrency, "Maximum number of concurrent per-service or per-device requests of a collector.")
+		laddrEnabled = flag.Bool("collector.laddr", false, "Enable the FNAT local address collector, which queries every FNAT service.")
+		fnatPorts    = flag.String("collector.laddr.port-range", "1025-65535", "Source port range usable by each FNAT local address.")
+		aclEnabled   = flag.Bool("collector.acl", false, "Enable the allow/deny list collector, which queries every service.")
+		aclEntries   = flag.Bool("collector.acl.entries", false, "Export an info series per allow/deny list entry.")
+		devEnabled   = flag.Bool("collector.device", false, "Enable the device address, route and VLAN inventory collector.")
+		ipsetEnabled = flag.Bool("collector.ipset", false, "Enable the ipset collector.")
+		accessList   = flag.Bool("collector.accesslist", false, "Enable the blacklist and whitelist collector, which runs dpip blklst and whtlst.")
+		accessListEn = flag.Bool("collector.accesslist.entries", false, "Export an info series per blacklist and whitelist entry.")
+		connState    = flag.Bool("collector.connstate", false, "Enable the connection state collector, which reads the connection table.")
+		connLimit    = flag.Int("collector.conntable.limit", collector.DefaultConnTableLimit, "Maximum number of connection table entries read per scrape.")
+		topTalkers   = flag.Bool("collector.toptalkers", false, "Enable the top talkers collector, which reads the connection table, and its /api/top-talkers endpoint.")
+		topTalkersN  = flag.Int("collector.toptalkers.n", collector.DefaultTopTalkers, "Number of client prefixes exported per service by the top talkers collector.")
+		eventHistory = flag.Int("collector.topology.events", collector.DefaultEventHistory, "Number of topology change events served by /api/events.")
+		flapWindow   = flag.Duration("collector.flap.window", collector.DefaultFlapWindow, "Sliding window over which real server state transitions are counted.")
+		flapThresh   = flag.Int("collector.flap.threshold", collector.DefaultFlapThreshold, "Number of real server state transitions within the window above which it is flapping.")
+		metadataFile = flag.String("collector.metadata.file", "", "YAML or CSV file mapping services and RS IPs to metadata, re-read when modified.")
+
+		webhookURLs      = flag.String("notify.webhook.urls", "", "Comma separated URLs to post real server state changes to as JSON.")
+		slackURLs        = flag.String("notify.slack.urls", "", "Comma separated Slack incoming webhook URLs to post real server state changes to.")
+		alertmanagerURLs = flag.String("notify.alertmanager.urls", "", "Comma separated Alertmanager alert API URLs, e.g. http://alertmanager:9093/api/v2/alerts, to post real server state changes to.")
+		notifyInterval   = flag.Duration("notify.interval", notify.DefaultInterval, "Interval at which real server states are polled for notifications and active Alertmanager alerts are re-posted; keep it below Alertmanager's resolve_timeout.")
+		notifyDebounce   = flag.Duration("notify.debounce", notify.DefaultDebounce, "Time a real server must stay in a new state before it is notified.")
+		notifyRetries    = flag.Int("notify.retries", notify.DefaultRetries, "Number of times a failed notification is retried.")
 	)
 	flag.Parse()
-	agent := lb.NewDpvsAgentComm("")
-	nicName, err := agent.ListNicName()
+
+	var (
+		connFilter = &collector.ConnFilter{VSAggregates: *vsOnly}
+		nicFilter  = &collector.NicFilter{}
+		err        error
+	)
+	for _, f := range []struct {
+		flag  string
+		value string
+		nets  *[]*net.IPNet
+	}{
+		{"vip-include", *vipInclude, &connFilter.VIPInclude},
+		{"vip-exclude", *vipExclude, &connFilter.VIPExclude},
+		{"rs-include", *rsInclude, &connFilter.RSInclude},
+		{"rs-exclude", *rsExclude, &connFilter.RSExclude},
+	} {
+		if *f.nets, err = collector.ParseCIDRList(f.value); err != nil {
+			log.Fatalf("Invalid collector.conn.%s: %v", f.flag, err)
+		}
+	}
+	if connFilter.PortInclude, err = collector.ParsePortRanges(*portInclude); err != nil {
+		log.Fatalf("Invalid collector.conn.port-include: %v", err)
+	}
+	if connFilter.PortExclude, err = collector.ParsePortRanges(*portExclude); err != nil {
+		log.Fatalf("Invalid collector.conn.port-exclude: %v", err)
+	}
+	if connFilter.Protocols, err = collector.ParseProtocols(*protocols); err != nil {
+		log.Fatalf("Invalid collector.conn.protocols: %v", err)
+	}
+	ports, err := collector.ParsePortRanges(*fnatPorts)
+	if err != nil || len(ports) != 1 {
+		log.Fatalf("Invalid collector.laddr.port-range: %q", *fnatPorts)
+	}
+	seriesLimits, err := collector.ParseSeriesLimits(*maxSeries)
+	if err != nil {
+		log.Fatalf("Invalid collector.max-series: %v", err)
+	}
+	if *nicInclude != "" {
+		if nicFilter.Include, err = regexp.Compile(*nicInclude); err != nil {
+			log.Fatalf("Invalid collector.nic.name-include: %v", err)
+		}
+	}
+	if *nicExclude != "" {
+		if nicFilter.Exclude, err = regexp.Compile(*nicExclude); err != nil {
+			log.Fatalf("Invalid collector.nic.name-exclude: %v", err)
+		}
+	}
+
+	var backend lb.Backend
+	switch *backendName {
+	case "agent":
+		backend = lb.NewDpvsAgentComm("")
+	case "socket":
+		backend = lb.NewDpvsSocketComm(*socketPath)
+	case "command":
+		backend = lb.NewDpvsCommandComm(*ipvsadmPath, *dpipPath)
+	default:
+		log.Fatalf("Unknown dpvs.backend %q", *backendName)
+	}
+	nicName, err := backend.ListNicName()
 	if err != nil || nicName == nil {
 		fmt.Println(err.Error())
 		return
 	}
-	serverInfo, err := agent.ListVirtualServices()
+	serverInfo, err := backend.ListVirtualServices()
 	if err != nil || serverInfo == nil {
 		return
 	}
 
-	collector.InitConnStatsController(serverInfo.Items)
-	collector.InitNicCollector(nicName)
-	dpvs := collector.NewDpvs(*agent)
+	collector.InitConnStatsController(serverInfo.Items, connFilter)
+	collector.InitNicCollector(nicName, nicFilter)
+	commands := lb.NewDpvsCommandComm(*ipvsadmPath, *dpipPath)
+	dpvs := collector.NewDpvs(backend, collector.Options{
+		Timeout:      *timeout,
+		MaxSeries:    seriesLimits,
+		MetadataFile: *metadataFile,
+		Enabled: map[string]bool{
+			"laddr":      *laddrEnabled,
+			"acl":        *aclEnabled,
+			"device":     *devEnabled,
+			"ipset":      *ipsetEnabled,
+			"connstate":  *connState,
+			"toptalkers": *topTalkers,
+			"accesslist": *accessList,
+		},
+		Concurrency:       *concurrency,
+		FNATPorts:         ports[0],
+		ACLEntries:        *aclEntries,
+		ConnTable:         commands,
+		ConnTableLimit:    *connLimit,
+		AccessLists:       commands,
+		AccessListEntries: *accessListEn,
+		EventHistory:      *eventHistory,
+		FlapWindow:        *flapWindow,
+		FlapThreshold:     *flapThresh,
+		TopTalkers:        *topTalkersN,
+		ProbeInterval:     *probeInterval,
+	})
 	prometheus.MustRegister(dpvs)
+	dpvs.RegisterAPI(http.DefaultServeMux)
+
+	var senders []notify.Sender
+	for _, hooks := range []struct {
+		urls   string
+		format string
+	}{
+		{*webhookURLs, notify.FormatGeneric},
+		{*slackURLs, notify.FormatSlack},
+		{*alertmanagerURLs, notify.FormatAlertmanager},
+	} {
+		for _, url := range strings.Split(hooks.urls, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				senders = append(senders, notify.NewWebhook(url, hooks.format, *notifyRetries))
+			}
+		}
+	}
+	if len(senders) > 0 {
+		go notify.NewWatcher(backend, senders, *notifyInterval, *notifyDebounce).Run()
+	}
 
 	http.Handle(*metricsPath, promhttp.Handler())
 	log.Printf("Starting dpvs_exporter on %s%s\n", *listenAddress, *metricsPath)
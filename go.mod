@@ -7,9 +7,11 @@ toolchain go1.23.9
 require (
 	github.com/alecthomas/kingpin/v2 v2.4.0
 	github.com/prometheus/client_golang v1.22.0
+	github.com/prometheus/client_model v0.6.1
 	github.com/prometheus/common v0.62.0
 	github.com/prometheus/exporter-toolkit v0.14.0
 	github.com/prometheus/node_exporter v1.9.1
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -42,7 +44,6 @@ require (
 	github.com/opencontainers/selinux v1.11.1 // indirect
 	github.com/power-devops/perfstat v0.0.0-20240221224432-82ca36839d55 // indirect
 	github.com/prometheus-community/go-runit v0.1.0 // indirect
-	github.com/prometheus/client_model v0.6.1 // indirect
 	github.com/prometheus/procfs v0.15.2-0.20240603130017-1754b780536b // indirect
 	github.com/safchain/ethtool v0.5.10 // indirect
 	github.com/xhit/go-str2duration/v2 v2.1.0 // indirect
@@ -56,6 +57,5 @@ require (
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/protobuf v1.36.5 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	howett.net/plist v1.0.1 // indirect
 )
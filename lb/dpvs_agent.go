@@ -17,10 +17,12 @@ package lb
 import (
 	"dpvs_exporter/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -29,6 +31,14 @@ var (
 	serverDefault = "localhost:53225"
 	listUri       = LbApi{"/v2/vs", http.MethodGet}
 	listNicUri    = LbApi{"/v2/device/name/nic?verbose=false&stats=true", http.MethodGet}
+	listLaddrUri  = LbApi{"/v2/vs/%s/laddr", http.MethodGet}
+	listAllowUri  = LbApi{"/v2/vs/%s/allow", http.MethodGet}
+	listDenyUri   = LbApi{"/v2/vs/%s/deny", http.MethodGet}
+	listAddrUri   = LbApi{"/v2/device/%s/addr", http.MethodGet}
+	listRouteUri  = LbApi{"/v2/device/%s/route", http.MethodGet}
+	listVlanUri   = LbApi{"/v2/device/%s/vlan", http.MethodGet}
+	listIpsetUri  = LbApi{"/v2/ipset", http.MethodGet}
+	versionUri    = LbApi{"/v2/version", http.MethodGet}
 
 	client *http.Client = &http.Client{Timeout: httpClientTimeout}
 )
@@ -38,6 +48,14 @@ const httpClientTimeout = 10 * time.Second
 type DpvsAgentComm struct {
 	listApi     LbApi
 	listNicApis LbApi
+	laddrApi    LbApi // Url is a format string taking the VipPort
+	allowApi    LbApi // Url is a format string taking the VipPort
+	denyApi     LbApi // Url is a format string taking the VipPort
+	addrApi     LbApi // Url is a format string taking the device name
+	routeApi    LbApi // Url is a format string taking the device name
+	vlanApi     LbApi // Url is a format string taking the device name
+	ipsetApi    LbApi
+	versionApi  LbApi
 }
 
 type LbApi struct {
@@ -151,9 +169,95 @@ func NewDpvsAgentComm(server string) *DpvsAgentComm {
 	return &DpvsAgentComm{
 		listApi:     LbApi{addr + listUri.Url, listUri.HttpMethod},
 		listNicApis: LbApi{addr + listNicUri.Url, listNicUri.HttpMethod},
+		laddrApi:    LbApi{addr + listLaddrUri.Url, listLaddrUri.HttpMethod},
+		allowApi:    LbApi{addr + listAllowUri.Url, listAllowUri.HttpMethod},
+		denyApi:     LbApi{addr + listDenyUri.Url, listDenyUri.HttpMethod},
+		addrApi:     LbApi{addr + listAddrUri.Url, listAddrUri.HttpMethod},
+		routeApi:    LbApi{addr + listRouteUri.Url, listRouteUri.HttpMethod},
+		vlanApi:     LbApi{addr + listVlanUri.Url, listVlanUri.HttpMethod},
+		ipsetApi:    LbApi{addr + listIpsetUri.Url, listIpsetUri.HttpMethod},
+		versionApi:  LbApi{addr + versionUri.Url, versionUri.HttpMethod},
 	}
 }
 
+// VipPort returns the identifier dpvs-agent uses for a virtual service in its
+// per-service URLs, e.g. 192.168.88.1-80-tcp. It is empty for services not
+// keyed by address and port, such as fwmark services.
+func VipPort(vs *VirtualServerSpecExpand) string {
+	if vs.Addr == nil || vs.Port == nil || vs.Proto == nil {
+		return ""
+	}
+	if vs.Fwmark != nil && *vs.Fwmark != 0 {
+		return ""
+	}
+	return strings.ToLower(fmt.Sprintf("%s-%d-%s", *vs.Addr, *vs.Port,
+		utils.IPProto(*vs.Proto)))
+}
+
+// doRequest calls url and decodes the JSON reply into v. An empty body leaves
+// v untouched, while a non-2xx status is reported as a *StatusError.
+func doRequest(method, url string, v interface{}) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// StatusError is returned when dpvs-agent replies with a non-2xx status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d", e.URL, e.StatusCode)
+}
+
+// ListLocalAddresses returns the FNAT local addresses of the service
+// identified by vipPort, see VipPort.
+func (comm *DpvsAgentComm) ListLocalAddresses(vipPort string) ([]LocalAddressSpecExpand, error) {
+	var laddrs LocalAddressExpandList
+	if err := doRequest(comm.laddrApi.HttpMethod, fmt.Sprintf(comm.laddrApi.Url, vipPort), &laddrs); err != nil {
+		return nil, err
+	}
+	return laddrs.Items, nil
+}
+
+// ListAllowList returns the allow list of the service identified by vipPort.
+func (comm *DpvsAgentComm) ListAllowList(vipPort string) ([]CertAuthSpec, error) {
+	var acl AclAddrList
+	if err := doRequest(comm.allowApi.HttpMethod, fmt.Sprintf(comm.allowApi.Url, vipPort), &acl); err != nil {
+		return nil, err
+	}
+	return acl.Items, nil
+}
+
+// ListDenyList returns the deny list of the service identified by vipPort.
+func (comm *DpvsAgentComm) ListDenyList(vipPort string) ([]CertAuthSpec, error) {
+	var acl AclAddrList
+	if err := doRequest(comm.denyApi.HttpMethod, fmt.Sprintf(comm.denyApi.Url, vipPort), &acl); err != nil {
+		return nil, err
+	}
+	return acl.Items, nil
+}
+
 func (comm *DpvsAgentComm) ListVirtualServices() (*VsResponse, error) {
 	req, err := http.NewRequest(comm.listApi.HttpMethod, comm.listApi.Url, nil)
 	if err != nil {
@@ -247,6 +351,186 @@ func (comm *DpvsAgentComm) ListNicName() ([]string, error) {
 	return ret, nil
 }
 
+// ListDeviceAddrs returns the interface addresses of device.
+func (comm *DpvsAgentComm) ListDeviceAddrs(device string) ([]InetAddrSpec, error) {
+	var addrs InetAddrList
+	if err := doRequest(comm.addrApi.HttpMethod, fmt.Sprintf(comm.addrApi.Url, url.PathEscape(device)), &addrs); err != nil {
+		return nil, err
+	}
+	return addrs.Items, nil
+}
+
+// ListDeviceRoutes returns the routes via device.
+func (comm *DpvsAgentComm) ListDeviceRoutes(device string) ([]RouteSpec, error) {
+	var routes RouteList
+	if err := doRequest(comm.routeApi.HttpMethod, fmt.Sprintf(comm.routeApi.Url, url.PathEscape(device)), &routes); err != nil {
+		return nil, err
+	}
+	return routes.Items, nil
+}
+
+// ListDeviceVlans returns the VLAN devices on top of device.
+func (comm *DpvsAgentComm) ListDeviceVlans(device string) ([]VlanSpec, error) {
+	var vlans VlanList
+	if err := doRequest(comm.vlanApi.HttpMethod, fmt.Sprintf(comm.vlanApi.Url, url.PathEscape(device)), &vlans); err != nil {
+		return nil, err
+	}
+	return vlans.Items, nil
+}
+
+// ListIpsets returns all ipsets with their members.
+func (comm *DpvsAgentComm) ListIpsets() ([]IpsetInfo, error) {
+	var sets IpsetInfoArray
+	if err := doRequest(comm.ipsetApi.HttpMethod, comm.ipsetApi.Url, &sets); err != nil {
+		return nil, err
+	}
+	return sets.Infos, nil
+}
+
+// Endpoint groups reported by Probe.
+const (
+	EndpointVS     = "vs"
+	EndpointNic    = "nic"
+	EndpointLaddr  = "laddr"
+	EndpointACL    = "acl"
+	EndpointDevice = "device"
+	EndpointIpset  = "ipset"
+)
+
+// AgentInfo describes the API served by a dpvs-agent.
+type AgentInfo struct {
+	Version   string
+	Endpoints map[string]bool  // keyed by the Endpoint constants
+	Errors    map[string]error // groups that couldn't be probed, not in Endpoints
+}
+
+// Probe detects the version of the agent and which endpoint groups it
+// serves. An endpoint answering 404, 405 or 501 is unsupported; a group
+// failing otherwise, e.g. with a 500, is unknown and its failure recorded in
+// Errors, and the other groups are probed nonetheless. Probe only fails when
+// no group could be probed, e.g. when the agent is down. Per-service and
+// per-device endpoints are probed against the first service and NIC, and
+// assumed to be supported if there is none; they are unknown when the
+// services or NICs can't be listed. Agents without a version endpoint report
+// "unknown".
+func (comm *DpvsAgentComm) Probe() (*AgentInfo, error) {
+	info := &AgentInfo{
+		Version:   "unknown",
+		Endpoints: make(map[string]bool),
+		Errors:    make(map[string]error),
+	}
+	var version struct {
+		Version *string `json:"version,omitempty"`
+	}
+	// The version is informational, failures are left to the probes below.
+	if ok, err := probe(comm.versionApi, comm.versionApi.Url, &version); err == nil && ok && version.Version != nil && *version.Version != "" {
+		info.Version = *version.Version
+	}
+	var services VsResponse
+	ok, err := probe(comm.listApi, comm.listApi.Url, &services)
+	if info.record(EndpointVS, ok, err) {
+		comm.probeServices(info, services.Items)
+	} else {
+		info.record(EndpointLaddr, false, err)
+		info.record(EndpointACL, false, err)
+	}
+	var nics NICStatsResponse
+	ok, err = probe(comm.listNicApis, comm.listNicApis.Url, &nics)
+	if info.record(EndpointNic, ok, err) {
+		comm.probeDevices(info, nics.Items)
+	} else {
+		info.record(EndpointDevice, false, err)
+	}
+	ok, err = probe(comm.ipsetApi, comm.ipsetApi.Url, &IpsetInfoArray{})
+	info.record(EndpointIpset, ok, err)
+
+	if len(info.Endpoints) == 0 {
+		return nil, info.Errors[EndpointVS]
+	}
+	return info, nil
+}
+
+// record sets whether group is supported, or its failure, and reports
+// whether it could be probed.
+func (info *AgentInfo) record(group string, ok bool, err error) bool {
+	if err != nil {
+		delete(info.Endpoints, group)
+		info.Errors[group] = err
+		return false
+	}
+	delete(info.Errors, group)
+	info.Endpoints[group] = ok
+	return true
+}
+
+// probeServices probes the per-service endpoint groups against the first of
+// services with per-service URLs.
+func (comm *DpvsAgentComm) probeServices(info *AgentInfo, services []VirtualServerSpecExpand) {
+	info.record(EndpointLaddr, true, nil)
+	info.record(EndpointACL, true, nil)
+	for _, vs := range services {
+		vipPort := VipPort(&vs)
+		if vipPort == "" {
+			continue
+		}
+		ok, err := probe(comm.laddrApi, fmt.Sprintf(comm.laddrApi.Url, vipPort), &LocalAddressExpandList{})
+		info.record(EndpointLaddr, ok, err)
+		allow, err := probe(comm.allowApi, fmt.Sprintf(comm.allowApi.Url, vipPort), &AclAddrList{})
+		if info.record(EndpointACL, allow, err) {
+			deny, err := probe(comm.denyApi, fmt.Sprintf(comm.denyApi.Url, vipPort), &AclAddrList{})
+			info.record(EndpointACL, allow && deny, err)
+		}
+		return
+	}
+}
+
+// probeDevices probes the per-device endpoint group against the first of
+// nics.
+func (comm *DpvsAgentComm) probeDevices(info *AgentInfo, nics []NICDeviceSpec) {
+	info.record(EndpointDevice, true, nil)
+	for _, nic := range nics {
+		if nic.Detail == nil || nic.Detail.Name == nil {
+			continue
+		}
+		name := url.PathEscape(*nic.Detail.Name)
+		for _, api := range []LbApi{comm.addrApi, comm.routeApi, comm.vlanApi} {
+			ok, err := probe(api, fmt.Sprintf(api.Url, name), &struct{}{})
+			if !info.record(EndpointDevice, info.Endpoints[EndpointDevice] && ok, err) {
+				return
+			}
+		}
+		return
+	}
+}
+
+// IsUnsupported reports whether err is the reply of an agent that doesn't
+// serve the endpoint, as opposed to one failing to.
+func IsUnsupported(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		switch se.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnreachable reports whether err is a failure to connect to the agent, as
+// opposed to a failing reply.
+func IsUnreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// probe calls url and reports whether the agent serves it.
+func probe(api LbApi, url string, v interface{}) (bool, error) {
+	err := doRequest(api.HttpMethod, url, v)
+	if IsUnsupported(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // 安全的解引用字符串（如果是 nil 返回空字符串）
 func safeDereference(ptr *string) string {
 	if ptr == nil {
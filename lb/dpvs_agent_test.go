@@ -0,0 +1,192 @@
+package lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newFakeAgent serves replies, keyed by URL path, with a 200 status, and the
+// statuses for the paths in statuses; any other path is a 404.
+func newFakeAgent(t *testing.T, replies map[string]string, statuses map[string]int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, ok := statuses[r.URL.Path]; ok {
+			w.WriteHeader(status)
+			return
+		}
+		reply, ok := replies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// agentReplies are the replies of an agent serving every endpoint group. The
+// fwmark service has no per-service URLs, so the next one is probed.
+var agentReplies = map[string]string{
+	"/v2/version":                  `{"version": "1.9.6"}`,
+	"/v2/vs":                       `{"Items": [{"Fwmark": 10, "Proto": 6}, {"Addr": "10.0.0.1", "Port": 80, "Proto": 6}, {"Addr": "10.0.0.2", "Port": 80, "Proto": 6}]}`,
+	"/v2/device/name/nic":          `{"Items": [{"detail": {"name": "dpdk0"}}, {"detail": {"name": "dpdk1"}}]}`,
+	"/v2/ipset":                    `{}`,
+	"/v2/vs/10.0.0.1-80-tcp/laddr": `{}`,
+	"/v2/vs/10.0.0.1-80-tcp/allow": `{}`,
+	"/v2/vs/10.0.0.1-80-tcp/deny":  `{}`,
+	"/v2/device/dpdk0/addr":        `{}`,
+	"/v2/device/dpdk0/route":       `{}`,
+	"/v2/device/dpdk0/vlan":        `{}`,
+}
+
+func TestProbe(t *testing.T) {
+	all := map[string]bool{
+		EndpointVS: true, EndpointNic: true, EndpointLaddr: true,
+		EndpointACL: true, EndpointDevice: true, EndpointIpset: true,
+	}
+	without := func(groups ...string) map[string]bool {
+		endpoints := make(map[string]bool)
+		for group := range all {
+			endpoints[group] = true
+		}
+		for _, group := range groups {
+			endpoints[group] = false
+		}
+		return endpoints
+	}
+	tests := []struct {
+		name        string
+		replies     map[string]string
+		statuses    map[string]int
+		wantVersion string
+		want        map[string]bool
+		wantErrors  []string
+	}{
+		{"supported", agentReplies, nil, "1.9.6", all, nil},
+		{
+			"unsupported",
+			agentReplies,
+			map[string]int{
+				"/v2/vs/10.0.0.1-80-tcp/laddr": http.StatusNotFound,
+				"/v2/vs/10.0.0.1-80-tcp/deny":  http.StatusMethodNotAllowed,
+				"/v2/ipset":                    http.StatusNotImplemented,
+				"/v2/device/dpdk0/vlan":        http.StatusNotFound,
+			},
+			"1.9.6",
+			without(EndpointLaddr, EndpointACL, EndpointIpset, EndpointDevice),
+			nil,
+		},
+		{
+			// Only the first service and NIC are probed.
+			"first service and NIC",
+			agentReplies,
+			map[string]int{
+				"/v2/vs/10.0.0.2-80-tcp/laddr": http.StatusNotFound,
+				"/v2/device/dpdk1/addr":        http.StatusNotFound,
+			},
+			"1.9.6",
+			all,
+			nil,
+		},
+		{
+			"no version",
+			agentReplies,
+			map[string]int{"/v2/version": http.StatusNotFound},
+			"unknown",
+			all,
+			nil,
+		},
+		{
+			"no version number",
+			agentReplies,
+			map[string]int{"/v2/version": http.StatusInternalServerError},
+			"unknown",
+			all,
+			nil,
+		},
+		{
+			// Per-service and per-device endpoints are assumed supported.
+			"no service nor NIC",
+			map[string]string{
+				"/v2/vs":              `{}`,
+				"/v2/device/name/nic": `{}`,
+			},
+			nil,
+			"unknown",
+			without(EndpointIpset),
+			nil,
+		},
+		{
+			// Failing groups are unknown, the others are still probed.
+			"failing groups",
+			agentReplies,
+			map[string]int{
+				"/v2/ipset":                    http.StatusInternalServerError,
+				"/v2/vs/10.0.0.1-80-tcp/laddr": http.StatusInternalServerError,
+				"/v2/device/dpdk0/route":       http.StatusServiceUnavailable,
+			},
+			"1.9.6",
+			map[string]bool{EndpointVS: true, EndpointNic: true, EndpointACL: true},
+			[]string{EndpointDevice, EndpointIpset, EndpointLaddr},
+		},
+		{
+			"failing service list",
+			agentReplies,
+			map[string]int{"/v2/vs": http.StatusInternalServerError},
+			"1.9.6",
+			map[string]bool{EndpointNic: true, EndpointDevice: true, EndpointIpset: true},
+			[]string{EndpointACL, EndpointLaddr, EndpointVS},
+		},
+		{
+			"failing NIC list",
+			agentReplies,
+			map[string]int{"/v2/device/name/nic": http.StatusBadGateway},
+			"1.9.6",
+			map[string]bool{EndpointVS: true, EndpointLaddr: true, EndpointACL: true, EndpointIpset: true},
+			[]string{EndpointDevice, EndpointNic},
+		},
+	}
+	for _, tt := range tests {
+		srv := newFakeAgent(t, tt.replies, tt.statuses)
+		info, err := NewDpvsAgentComm(strings.TrimPrefix(srv.URL, "http://")).Probe()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if info.Version != tt.wantVersion || !reflect.DeepEqual(info.Endpoints, tt.want) {
+			t.Errorf("%s: got %s %v, want %s %v", tt.name, info.Version, info.Endpoints, tt.wantVersion, tt.want)
+		}
+		var errored []string
+		for group := range info.Errors {
+			errored = append(errored, group)
+		}
+		sort.Strings(errored)
+		if !reflect.DeepEqual(errored, tt.wantErrors) {
+			t.Errorf("%s: got failing groups %v, want %v", tt.name, errored, tt.wantErrors)
+		}
+	}
+}
+
+func TestProbeFailure(t *testing.T) {
+	// An agent failing every group.
+	srv := newFakeAgent(t, agentReplies, map[string]int{
+		"/v2/vs":              http.StatusInternalServerError,
+		"/v2/device/name/nic": http.StatusInternalServerError,
+		"/v2/ipset":           http.StatusInternalServerError,
+	})
+	if _, err := NewDpvsAgentComm(strings.TrimPrefix(srv.URL, "http://")).Probe(); err == nil {
+		t.Error("failing: expected an error")
+	}
+
+	// An agent that is down.
+	srv = newFakeAgent(t, agentReplies, nil)
+	srv.Close()
+	_, err := NewDpvsAgentComm(strings.TrimPrefix(srv.URL, "http://")).Probe()
+	if err == nil || !IsUnreachable(err) {
+		t.Errorf("down: got %v, want an unreachable agent", err)
+	}
+}
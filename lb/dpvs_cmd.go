@@ -0,0 +1,427 @@
+// Copyright 2023 IQiYi Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dpvs_exporter/utils"
+)
+
+const (
+	DefaultIpvsadmPath = "ipvsadm"
+	DefaultDpipPath    = "dpip"
+
+	commandTimeout = 10 * time.Second
+)
+
+// DpvsCommandComm reads the dataplane state by running DPVS's ipvsadm and
+// dpip and parsing their output, for nodes where neither dpvs-agent nor the
+// control socket is usable.
+type DpvsCommandComm struct {
+	ipvsadm string
+	dpip    string
+}
+
+func NewDpvsCommandComm(ipvsadm, dpip string) *DpvsCommandComm {
+	if len(ipvsadm) == 0 {
+		ipvsadm = DefaultIpvsadmPath
+	}
+	if len(dpip) == 0 {
+		dpip = DefaultDpipPath
+	}
+	return &DpvsCommandComm{
+		ipvsadm: ipvsadm,
+		dpip:    dpip,
+	}
+}
+
+// run runs name with args and returns its standard output.
+func run(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (comm *DpvsCommandComm) ListVirtualServices() (*VsResponse, error) {
+	out, err := run(comm.ipvsadm, "-ln", "--stats", "--exact")
+	if err != nil {
+		return nil, err
+	}
+	return parseIpvsadmStats(bytes.NewReader(out))
+}
+
+func (comm *DpvsCommandComm) ListNicStats() ([]*NICDeviceStats, error) {
+	out, err := run(comm.dpip, "link", "show", "-s")
+	if err != nil {
+		return nil, err
+	}
+	return parseDpipLinkStats(bytes.NewReader(out))
+}
+
+func (comm *DpvsCommandComm) ListNicName() ([]string, error) {
+	stats, err := comm.ListNicStats()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(stats))
+	for _, nic := range stats {
+		ret = append(ret, safeDereference(nic.Name))
+	}
+	return ret, nil
+}
+
+// ListConns lists the connection table with ipvsadm -lnc, stopping ipvsadm
+// once limit entries have been read or an entry is malformed.
+func (comm *DpvsCommandComm) ListConns(limit int) ([]ConnEntry, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, comm.ipvsadm, "-lnc")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, false, err
+	}
+	conns, truncated, err := parseIpvsadmConns(stdout, limit)
+	if truncated || err != nil {
+		// Nothing more will be read, don't wait for the whole table.
+		cmd.Process.Kill()
+		cmd.Wait()
+		return conns, truncated, err
+	}
+	if werr := cmd.Wait(); err == nil && werr != nil {
+		err = fmt.Errorf("%s -lnc: %v", comm.ipvsadm, werr)
+	}
+	return conns, false, err
+}
+
+// ListBlacklist lists the blacklists with dpip blklst show.
+func (comm *DpvsCommandComm) ListBlacklist() ([]AccessListEntry, error) {
+	out, err := run(comm.dpip, "blklst", "show")
+	if err != nil {
+		return nil, err
+	}
+	return parseDpipAccessList(bytes.NewReader(out))
+}
+
+// ListWhitelist lists the whitelists with dpip whtlst show.
+func (comm *DpvsCommandComm) ListWhitelist() ([]AccessListEntry, error) {
+	out, err := run(comm.dpip, "whtlst", "show")
+	if err != nil {
+		return nil, err
+	}
+	return parseDpipAccessList(bytes.NewReader(out))
+}
+
+// parseDpipAccessList parses the output of dpip blklst show or dpip whtlst
+// show, one entry per line after a header:
+//
+//	VIP:VPORT            PROTO    BLACKLIST
+//	192.168.100.254:80   TCP      192.168.88.1
+//
+// Some versions print the VIP and VPORT as separate columns.
+func parseDpipAccessList(r io.Reader) ([]AccessListEntry, error) {
+	var entries []AccessListEntry
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(strings.ToUpper(fields[0]), "VIP") {
+			continue
+		}
+		var (
+			entry AccessListEntry
+			err   error
+		)
+		switch len(fields) {
+		case 3:
+			entry.VIP, entry.Port, err = splitHostPort(fields[0])
+		case 4:
+			entry.VIP = strings.Trim(fields[0], "[]")
+			var port uint64
+			if port, err = strconv.ParseUint(fields[1], 10, 16); err != nil {
+				err = fmt.Errorf("invalid port %q", fields[1])
+			}
+			entry.Port = int64(port)
+		default:
+			return nil, fmt.Errorf("line %d: malformed entry", lineno)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		if entry.Proto = utils.IPProtoFromStr(fields[len(fields)-2]); entry.Proto == 0 {
+			return nil, fmt.Errorf("line %d: invalid protocol %q", lineno, fields[len(fields)-2])
+		}
+		entry.Addr = fields[len(fields)-1]
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+var ipvsadmConnRe = regexp.MustCompile(`^\[\s*\d+\]\s*(\S+)\s+\S+\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s*$`)
+
+// parseIpvsadmConns parses the output of ipvsadm -lnc, one connection per
+// line after a header:
+//
+//	[lcore]proto expire   state       source              virtual             local                 destination
+//	[ 1]tcp   90s    ESTABLISHED 192.168.88.1:50112  192.168.100.254:80  192.168.100.200:1025  192.168.100.2:80
+func parseIpvsadmConns(r io.Reader, limit int) ([]ConnEntry, bool, error) {
+	var conns []ConnEntry
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		m := ipvsadmConnRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		if limit > 0 && len(conns) == limit {
+			return conns, true, nil
+		}
+		conn := ConnEntry{
+			Proto: utils.IPProtoFromStr(m[1]),
+			State: m[2],
+		}
+		for i, addr := range []*ConnAddr{&conn.Client, &conn.Virtual, &conn.Local, &conn.Dest} {
+			ip, port, err := splitHostPort(m[3+i])
+			if err != nil {
+				return nil, false, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			*addr = ConnAddr{IP: ip, Port: port}
+		}
+		conns = append(conns, conn)
+	}
+	return conns, false, scanner.Err()
+}
+
+// parseIpvsadmStats parses the output of ipvsadm -ln --stats --exact:
+//
+//	Prot LocalAddress:Port               Conns   InPkts  OutPkts  InBytes OutBytes
+//	  -> RemoteAddress:Port
+//	TCP  192.168.100.254:80              1532    98213    87345 12034567 98234123
+//	  -> 192.168.100.2:80                 766    49107    43672  6017284 49117062
+//	FWM  100                                7       70       60     7000     6000
+//
+// Services of other kinds, and their real servers, are skipped.
+func parseIpvsadmStats(r io.Reader) (*VsResponse, error) {
+	vss := &VsResponse{}
+	var vs *VirtualServerSpecExpand
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "IP", "Prot":
+			continue
+		case "->":
+			if len(fields) == 2 && fields[1] == "RemoteAddress:Port" {
+				continue
+			}
+			if vs == nil {
+				continue
+			}
+			if len(fields) != 7 {
+				return nil, fmt.Errorf("line %d: malformed real server", lineno)
+			}
+			ip, port, err := splitHostPort(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			stats, err := parseIpvsadmCounters(fields[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			vs.RSs.Items = append(vs.RSs.Items, RealServerSpecExpand{
+				Spec:  &RealServerSpecTiny{IP: &ip, Port: &port},
+				Stats: stats,
+			})
+		case "FWM":
+			if len(fields) != 7 {
+				return nil, fmt.Errorf("line %d: malformed service", lineno)
+			}
+			mark, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid fwmark %q", lineno, fields[1])
+			}
+			stats, err := parseIpvsadmCounters(fields[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			vss.Items = append(vss.Items, VirtualServerSpecExpand{
+				Fwmark: &mark,
+				RSs:    &RealServerExpandList{},
+				Stats:  stats,
+			})
+			vs = &vss.Items[len(vss.Items)-1]
+		default:
+			proto := utils.IPProtoFromStr(fields[0])
+			if proto == 0 || len(fields) != 7 {
+				vs = nil
+				continue
+			}
+			addr, port, err := splitHostPort(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			stats, err := parseIpvsadmCounters(fields[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			af, protoNum := int64(utils.ParseIP(addr).AF()), int64(proto)
+			vss.Items = append(vss.Items, VirtualServerSpecExpand{
+				Addr:  &addr,
+				AF:    &af,
+				Port:  &port,
+				Proto: &protoNum,
+				RSs:   &RealServerExpandList{},
+				Stats: stats,
+			})
+			vs = &vss.Items[len(vss.Items)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vss, nil
+}
+
+// parseIpvsadmCounters parses the Conns InPkts OutPkts InBytes OutBytes columns.
+func parseIpvsadmCounters(fields []string) (*ServerStats, error) {
+	var v [5]int64
+	for i := range v {
+		n, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid counter %q", fields[i])
+		}
+		v[i] = n
+	}
+	return &ServerStats{
+		Conns:    &v[0],
+		InPkts:   &v[1],
+		OutPkts:  &v[2],
+		InBytes:  &v[3],
+		OutBytes: &v[4],
+	}, nil
+}
+
+func splitHostPort(s string) (string, int64, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q", s)
+	}
+	return host, int64(p), nil
+}
+
+var dpipLinkRe = regexp.MustCompile(`^\d+:\s+(\S+):`)
+
+// parseDpipLinkStats parses the output of dpip link show -s, where each
+// device starts with a "<id>: <name>:" line and its counters are given as
+// rows of names, each followed by a row of values:
+//
+//	1: dpdk0: socket 0 mtu 1500 rx-queue 8 tx-queue 8
+//	    ipackets            opackets            ibytes              obytes
+//	    9484                77                  1105766             6930
+func parseDpipLinkStats(r io.Reader) ([]*NICDeviceStats, error) {
+	var (
+		ret    []*NICDeviceStats
+		nic    *NICDeviceStats
+		header []string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := dpipLinkRe.FindStringSubmatch(line); m != nil {
+			nic = &NICDeviceStats{Name: ptr(m[1])}
+			ret = append(ret, nic)
+			header = nil
+			continue
+		}
+		fields := strings.Fields(line)
+		if nic == nil || len(fields) == 0 {
+			continue
+		}
+		if header != nil && len(fields) == len(header) {
+			values := make([]int64, len(fields))
+			numeric := true
+			for i, f := range fields {
+				var err error
+				if values[i], err = strconv.ParseInt(f, 10, 64); err != nil {
+					numeric = false
+					break
+				}
+			}
+			if numeric {
+				for i, name := range header {
+					setDpipCounter(nic, name, values[i])
+				}
+				header = nil
+				continue
+			}
+		}
+		header = fields
+		for _, f := range fields {
+			if _, known := dpipCounters[f]; !known {
+				header = nil
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// dpipCounters maps dpip counter names to the NICDeviceStats fields.
+var dpipCounters = map[string]func(*NICDeviceStats) **int64{
+	"ipackets":   func(s *NICDeviceStats) **int64 { return &s.InPkts },
+	"opackets":   func(s *NICDeviceStats) **int64 { return &s.OutPkts },
+	"ibytes":     func(s *NICDeviceStats) **int64 { return &s.InBytes },
+	"obytes":     func(s *NICDeviceStats) **int64 { return &s.OutBytes },
+	"ierrors":    func(s *NICDeviceStats) **int64 { return &s.InErrors },
+	"oerrors":    func(s *NICDeviceStats) **int64 { return &s.OutErrors },
+	"imissed":    func(s *NICDeviceStats) **int64 { return &s.InMissed },
+	"rx_nombuf":  func(s *NICDeviceStats) **int64 { return &s.RxNoMbuf },
+	"mbuf-avail": func(s *NICDeviceStats) **int64 { return &s.BufAvail },
+	"mbuf-inuse": func(s *NICDeviceStats) **int64 { return &s.BufInuse },
+}
+
+func setDpipCounter(nic *NICDeviceStats, name string, v int64) {
+	if field, known := dpipCounters[name]; known {
+		*field(nic) = ptr(v)
+	}
+}
@@ -0,0 +1,159 @@
+package lb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dpvs_exporter/utils"
+)
+
+func TestParseIpvsadmStats(t *testing.T) {
+	f, err := os.Open("testdata/ipvsadm_stats.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	vss, err := parseIpvsadmStats(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vss.Items) != 3 {
+		t.Fatalf("got %d services, want 3", len(vss.Items))
+	}
+
+	tcp := vss.Items[0]
+	if *tcp.Addr != "192.168.100.254" || *tcp.Port != 80 || *tcp.Proto != 6 || *tcp.AF != 2 ||
+		*tcp.Stats.Conns != 1532 || *tcp.Stats.InBytes != 12034567 || *tcp.Stats.OutBytes != 98234123 {
+		t.Errorf("unexpected TCP service %+v %+v", tcp, tcp.Stats)
+	}
+	if len(tcp.RSs.Items) != 2 {
+		t.Fatalf("got %d TCP real servers, want 2", len(tcp.RSs.Items))
+	}
+	if rs := tcp.RSs.Items[1]; *rs.Spec.IP != "192.168.100.3" || *rs.Spec.Port != 80 || *rs.Stats.InPkts != 49106 {
+		t.Errorf("unexpected TCP real server %+v %+v", rs.Spec, rs.Stats)
+	}
+
+	udp := vss.Items[1]
+	if *udp.Addr != "2001:db8::53" || *udp.Port != 53 || *udp.Proto != 17 || *udp.AF != 10 {
+		t.Errorf("unexpected UDP service %+v", udp)
+	}
+	if rs := udp.RSs.Items[0]; *rs.Spec.IP != "2001:db8:1::10" || *rs.Stats.OutBytes != 9408 {
+		t.Errorf("unexpected UDP real server %+v %+v", rs.Spec, rs.Stats)
+	}
+
+	fwm := vss.Items[2]
+	if *fwm.Fwmark != 100 || fwm.Addr != nil || *fwm.Stats.Conns != 7 || len(fwm.RSs.Items) != 1 {
+		t.Errorf("unexpected fwmark service %+v", fwm)
+	}
+}
+
+func TestParseDpipLinkStats(t *testing.T) {
+	f, err := os.Open("testdata/dpip_link_stats.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stats, err := parseDpipLinkStats(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d NICs, want 2", len(stats))
+	}
+	dpdk0 := stats[0]
+	if *dpdk0.Name != "dpdk0" || *dpdk0.InPkts != 9484 || *dpdk0.OutBytes != 6930 || *dpdk0.InErrors != 3 ||
+		*dpdk0.InMissed != 12 || *dpdk0.BufAvail != 1048575 || *dpdk0.BufInuse != 1024 {
+		t.Errorf("unexpected dpdk0 stats %+v", dpdk0)
+	}
+	if bond1 := stats[1]; *bond1.Name != "bond1" || *bond1.InBytes != 20000 || *bond1.BufInuse != 575 {
+		t.Errorf("unexpected bond1 stats %+v", bond1)
+	}
+}
+
+func TestParseIpvsadmConns(t *testing.T) {
+	f, err := os.Open("testdata/ipvsadm_conns.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	conns, truncated, err := parseIpvsadmConns(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated || len(conns) != 4 {
+		t.Fatalf("got %d connections, truncated %v; want 4, false", len(conns), truncated)
+	}
+	want := ConnEntry{
+		Proto:   utils.IPProtoTCP,
+		State:   "SYN_RECV",
+		Client:  ConnAddr{"192.168.88.2", 50113},
+		Virtual: ConnAddr{"192.168.100.254", 80},
+		Local:   ConnAddr{"192.168.100.200", 1026},
+		Dest:    ConnAddr{"192.168.100.3", 80},
+	}
+	if conns[1] != want {
+		t.Errorf("got %+v, want %+v", conns[1], want)
+	}
+	if c := conns[3]; c.Proto != utils.IPProtoUDP || c.Virtual != (ConnAddr{"2001:db8::53", 53}) {
+		t.Errorf("unexpected UDP connection %+v", c)
+	}
+
+	f.Seek(0, 0)
+	conns, truncated, err = parseIpvsadmConns(f, 2)
+	if err != nil || !truncated || len(conns) != 2 {
+		t.Errorf("got %d connections, truncated %v, err %v; want 2, true, nil", len(conns), truncated, err)
+	}
+}
+
+func TestParseDpipAccessList(t *testing.T) {
+	f, err := os.Open("testdata/dpip_blklst.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := parseDpipAccessList(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AccessListEntry{
+		{"192.168.100.254", 80, utils.IPProtoTCP, "192.168.88.1"},
+		{"192.168.100.254", 80, utils.IPProtoTCP, "192.168.88.2"},
+		{"2001:db8::53", 53, utils.IPProtoUDP, "2001:db8:2::1"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	entries, err = parseDpipAccessList(strings.NewReader("VIP    VPORT  PROTO  WHITELIST\n10.0.0.1  443  tcp  10.1.0.0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0] != (AccessListEntry{"10.0.0.1", 443, utils.IPProtoTCP, "10.1.0.0"}) {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func TestListConnsParseError(t *testing.T) {
+	// An ipvsadm printing a malformed connection, then stuck on a large table.
+	ipvsadm := filepath.Join(t.TempDir(), "ipvsadm")
+	script := "#!/bin/sh\necho '[ 1]tcp   90s    ESTABLISHED bogus     192.168.100.254:80     192.168.100.200:1025   192.168.100.2:80'\nexec sleep 30\n"
+	if err := os.WriteFile(ipvsadm, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	_, _, err := NewDpvsCommandComm(ipvsadm, "").ListConns(0)
+	if err == nil {
+		t.Error("expected a parse error")
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("ListConns returned after %s, ipvsadm wasn't killed", elapsed)
+	}
+}
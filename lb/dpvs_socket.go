@@ -0,0 +1,523 @@
+// Copyright 2023 IQiYi Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DefaultSocketPath is the unix socket DPVS serves its sockopt control
+// interface on, the one ipvsadm and dpip talk to.
+const DefaultSocketPath = "/var/run/dpvs.ipc"
+
+const socketTimeout = 10 * time.Second
+
+// The layouts below mirror the DPVS sockopt messages and the service, dest
+// and netif structures of include/conf. DPVS exchanges them in host byte
+// order, except for L4 ports which are in network byte order.
+const (
+	sockoptVersion   = 0x010000 // SOCKOPT_VERSION 1.0.0
+	sockoptGet       = 0        // SOCKOPT_GET
+	sockoptErrstrLen = 64
+	ifNameSize       = 16
+	schedNameLen     = 16
+	netifMaxQueues   = 16
+
+	soIDGetInfo        = 201 // DPVS_SO_GET_INFO
+	soIDGetServices    = 202 // DPVS_SO_GET_SERVICES
+	soIDGetDests       = 204 // DPVS_SO_GET_DESTS
+	soIDNetifPortList  = 503 // SOCKOPT_NETIF_GET_PORT_LIST
+	soIDNetifPortStats = 505 // SOCKOPT_NETIF_GET_PORT_STATS
+)
+
+// Forwarding modes, the low bits of the dest conn_flags.
+const (
+	fwdModeMask   = 0x0007
+	fwdModeNAT    = 0
+	fwdModeTunnel = 2
+	fwdModeDR     = 3
+	fwdModeFNAT   = 5
+	fwdModeSNAT   = 6
+)
+
+// Dest flags.
+const (
+	destFlagOverload  = 0x0002
+	destFlagInhibited = 0x0004
+)
+
+// struct dpvs_sock_msg
+type sockMsg struct {
+	Version uint32
+	ID      uint32
+	Type    uint32
+	_       [4]byte
+	Len     uint64
+}
+
+// struct dpvs_sock_msg_reply
+type sockMsgReply struct {
+	Version uint32
+	ID      uint32
+	Type    uint32
+	Errcode int32
+	Errstr  [sockoptErrstrLen]byte
+	Len     uint64
+}
+
+// struct dp_vs_getinfo
+type soGetInfoReply struct {
+	Version     uint32
+	Size        uint32
+	NumServices uint32
+}
+
+// struct dp_vs_stats
+type soStats struct {
+	Conns    uint64
+	InPkts   uint64
+	InBytes  uint64
+	OutPkts  uint64
+	OutBytes uint64
+	CPS      uint32
+	InPps    uint32
+	InBps    uint32
+	OutPps   uint32
+	OutBps   uint32
+	_        [4]byte
+}
+
+// struct inet_addr_range
+type soAddrRange struct {
+	MinAddr [16]byte
+	MaxAddr [16]byte
+	MinPort [2]byte
+	MaxPort [2]byte
+}
+
+// struct dp_vs_get_services, followed by the entry table.
+type soGetServices struct {
+	Cid         uint8
+	Index       uint8
+	_           [2]byte
+	NumServices uint32
+}
+
+// struct dp_vs_service_entry
+type soServiceEntry struct {
+	AF              int32
+	Proto           uint16
+	_               [2]byte
+	Addr            [16]byte
+	Port            [2]byte
+	_               [2]byte
+	Fwmark          uint32
+	SchedName       [schedNameLen]byte
+	Flags           uint32
+	Timeout         uint32
+	ConnTimeout     uint32
+	Netmask         uint32
+	Bps             uint32
+	LimitProportion uint32
+	NumDests        uint32
+	NumLaddrs       uint32
+	Cid             uint8
+	Index           uint8
+	_               [6]byte
+	Stats           soStats
+	SrcRange        soAddrRange
+	DstRange        soAddrRange
+	IifName         [ifNameSize]byte
+	OifName         [ifNameSize]byte
+}
+
+// struct dp_vs_get_dests, followed by the entry table.
+type soGetDests struct {
+	AF       int32
+	Proto    uint16
+	_        [2]byte
+	Addr     [16]byte
+	Port     [2]byte
+	_        [2]byte
+	Fwmark   uint32
+	NumDests uint32
+	Cid      uint8
+	Index    uint8
+	_        [2]byte
+}
+
+// struct dp_vs_dest_entry
+type soDestEntry struct {
+	AF           int32
+	Addr         [16]byte
+	Port         [2]byte
+	_            [2]byte
+	ConnFlags    uint32
+	Flags        uint32
+	Weight       int32
+	MaxConn      uint32
+	MinConn      uint32
+	ActConns     uint32
+	InactConns   uint32
+	PersistConns uint32
+	Stats        soStats
+}
+
+// struct netif_nic_list_get, followed by the port id/name table.
+type soNicList struct {
+	NicNum      uint16
+	PhyPidBase  uint16
+	PhyPidEnd   uint16
+	BondPidBase uint16
+	BondPidEnd  uint16
+}
+
+// struct port_id_name
+type soPortIDName struct {
+	ID   uint16
+	Name [ifNameSize]byte
+}
+
+// struct netif_nic_stats_get
+type soNicStats struct {
+	MbufAvail uint32
+	MbufInuse uint32
+	InPkts    uint64
+	OutPkts   uint64
+	InBytes   uint64
+	OutBytes  uint64
+	InMissed  uint64
+	InErrors  uint64
+	OutErrors uint64
+	RxNoMbuf  uint64
+	InPktsQ   [netifMaxQueues]uint64
+	OutPktsQ  [netifMaxQueues]uint64
+	InBytesQ  [netifMaxQueues]uint64
+	OutBytesQ [netifMaxQueues]uint64
+	ErrorsQ   [netifMaxQueues]uint64
+}
+
+// DpvsSocketComm reads the dataplane state straight from the DPVS control
+// socket, for nodes without dpvs-agent.
+type DpvsSocketComm struct {
+	path string
+}
+
+func NewDpvsSocketComm(path string) *DpvsSocketComm {
+	if len(path) == 0 {
+		path = DefaultSocketPath
+	}
+	return &DpvsSocketComm{
+		path: path,
+	}
+}
+
+// getsockopt sends a SOCKOPT_GET request with the encoded in and returns the
+// reply payload.
+func (comm *DpvsSocketComm) getsockopt(id uint32, in interface{}) ([]byte, error) {
+	var req bytes.Buffer
+	if in != nil {
+		if err := binary.Write(&req, binary.NativeEndian, in); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.DialTimeout("unix", comm.path, socketTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	msg := sockMsg{
+		Version: sockoptVersion,
+		ID:      id,
+		Type:    sockoptGet,
+		Len:     uint64(req.Len()),
+	}
+	if err := binary.Write(conn, binary.NativeEndian, &msg); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var reply sockMsgReply
+	if err := binary.Read(conn, binary.NativeEndian, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Version != sockoptVersion || reply.ID != id {
+		return nil, fmt.Errorf("sockopt %d: unexpected reply version 0x%x id %d", id, reply.Version, reply.ID)
+	}
+	if reply.Errcode != 0 {
+		return nil, fmt.Errorf("sockopt %d: %s (%d)", id, cString(reply.Errstr[:]), reply.Errcode)
+	}
+	data := make([]byte, reply.Len)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (comm *DpvsSocketComm) ListVirtualServices() (*VsResponse, error) {
+	data, err := comm.getsockopt(soIDGetInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info soGetInfoReply
+	if err := binary.Read(bytes.NewReader(data), binary.NativeEndian, &info); err != nil {
+		return nil, err
+	}
+
+	data, err = comm.getsockopt(soIDGetServices, &soGetServices{NumServices: info.NumServices})
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(data)
+	var hdr soGetServices
+	if err := binary.Read(r, binary.NativeEndian, &hdr); err != nil {
+		return nil, err
+	}
+	entries := make([]soServiceEntry, hdr.NumServices)
+	if err := binary.Read(r, binary.NativeEndian, entries); err != nil {
+		return nil, err
+	}
+
+	vss := &VsResponse{Items: make([]VirtualServerSpecExpand, 0, len(entries))}
+	for i := range entries {
+		vs := entries[i].toSpec()
+		dests, err := comm.listDests(&entries[i])
+		if err != nil {
+			return nil, err
+		}
+		vs.RSs = &RealServerExpandList{Items: dests}
+		vss.Items = append(vss.Items, vs)
+	}
+	return vss, nil
+}
+
+func (comm *DpvsSocketComm) listDests(svc *soServiceEntry) ([]RealServerSpecExpand, error) {
+	data, err := comm.getsockopt(soIDGetDests, &soGetDests{
+		AF:       svc.AF,
+		Proto:    svc.Proto,
+		Addr:     svc.Addr,
+		Port:     svc.Port,
+		Fwmark:   svc.Fwmark,
+		NumDests: svc.NumDests,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(data)
+	var hdr soGetDests
+	if err := binary.Read(r, binary.NativeEndian, &hdr); err != nil {
+		return nil, err
+	}
+	entries := make([]soDestEntry, hdr.NumDests)
+	if err := binary.Read(r, binary.NativeEndian, entries); err != nil {
+		return nil, err
+	}
+	rss := make([]RealServerSpecExpand, 0, len(entries))
+	for i := range entries {
+		rss = append(rss, entries[i].toSpec())
+	}
+	return rss, nil
+}
+
+func (comm *DpvsSocketComm) listPorts() ([]string, error) {
+	data, err := comm.getsockopt(soIDNetifPortList, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(data)
+	var hdr soNicList
+	if err := binary.Read(r, binary.NativeEndian, &hdr); err != nil {
+		return nil, err
+	}
+	ports := make([]soPortIDName, hdr.NicNum)
+	if err := binary.Read(r, binary.NativeEndian, ports); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(ports))
+	for _, port := range ports {
+		names = append(names, cString(port.Name[:]))
+	}
+	return names, nil
+}
+
+func (comm *DpvsSocketComm) ListNicName() ([]string, error) {
+	return comm.listPorts()
+}
+
+func (comm *DpvsSocketComm) ListNicStats() ([]*NICDeviceStats, error) {
+	names, err := comm.listPorts()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*NICDeviceStats, 0, len(names))
+	for _, name := range names {
+		var req [ifNameSize]byte
+		copy(req[:], name)
+		data, err := comm.getsockopt(soIDNetifPortStats, &req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		var stats soNicStats
+		if err := binary.Read(bytes.NewReader(data), binary.NativeEndian, &stats); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		nic := stats.toStats()
+		nic.Name = ptr(name)
+		ret = append(ret, nic)
+	}
+	return ret, nil
+}
+
+func (e *soServiceEntry) toSpec() VirtualServerSpecExpand {
+	sched := SchedName(cString(e.SchedName[:]))
+	vs := VirtualServerSpecExpand{
+		Addr:            ptr(sockAddr(e.AF, e.Addr)),
+		AF:              ptr(int64(e.AF)),
+		Bps:             ptr(int64(e.Bps)),
+		ConnTimeout:     ptr(int64(e.ConnTimeout)),
+		Fwmark:          ptr(int64(e.Fwmark)),
+		LimitProportion: ptr(int64(e.LimitProportion)),
+		Netmask:         ptr(int64(e.Netmask)),
+		Port:            ptr(int64(binary.BigEndian.Uint16(e.Port[:]))),
+		Proto:           ptr(int64(e.Proto)),
+		SchedName:       &sched,
+		Stats:           e.Stats.toStats(),
+		Timeout:         ptr(int64(e.Timeout)),
+	}
+	match := MatchSpec{
+		Src:  e.SrcRange.toRange(e.AF),
+		Dest: e.DstRange.toRange(e.AF),
+	}
+	if name := cString(e.IifName[:]); name != "" {
+		match.InIfName = &name
+	}
+	if name := cString(e.OifName[:]); name != "" {
+		match.OutIfName = &name
+	}
+	if match != (MatchSpec{}) {
+		vs.Match = &match
+	}
+	return vs
+}
+
+func (e *soDestEntry) toSpec() RealServerSpecExpand {
+	var mode Mode
+	switch e.ConnFlags & fwdModeMask {
+	case fwdModeNAT:
+		mode = Nat
+	case fwdModeTunnel:
+		mode = Tunnel
+	case fwdModeDR:
+		mode = DR
+	case fwdModeFNAT:
+		mode = Fnat
+	case fwdModeSNAT:
+		mode = Snat
+	}
+	return RealServerSpecExpand{
+		Spec: &RealServerSpecTiny{
+			Inhibited:  ptr(e.Flags&destFlagInhibited != 0),
+			IP:         ptr(sockAddr(e.AF, e.Addr)),
+			Mode:       &mode,
+			Overloaded: ptr(e.Flags&destFlagOverload != 0),
+			Port:       ptr(int64(binary.BigEndian.Uint16(e.Port[:]))),
+			Weight:     ptr(int64(e.Weight)),
+		},
+		Stats: e.Stats.toStats(),
+	}
+}
+
+func (s *soStats) toStats() *ServerStats {
+	return &ServerStats{
+		Conns:    ptr(int64(s.Conns)),
+		CPS:      ptr(int64(s.CPS)),
+		InBps:    ptr(int64(s.InBps)),
+		InBytes:  ptr(int64(s.InBytes)),
+		InPkts:   ptr(int64(s.InPkts)),
+		InPps:    ptr(int64(s.InPps)),
+		OutBps:   ptr(int64(s.OutBps)),
+		OutBytes: ptr(int64(s.OutBytes)),
+		OutPkts:  ptr(int64(s.OutPkts)),
+		OutPps:   ptr(int64(s.OutPps)),
+	}
+}
+
+func (r *soAddrRange) toRange(af int32) *AddrRange {
+	if *r == (soAddrRange{}) {
+		return nil
+	}
+	return &AddrRange{
+		Start: ptr(sockAddr(af, r.MinAddr)),
+		End:   ptr(sockAddr(af, r.MaxAddr)),
+	}
+}
+
+func (s *soNicStats) toStats() *NICDeviceStats {
+	return &NICDeviceStats{
+		BufAvail:    ptr(int64(s.MbufAvail)),
+		BufInuse:    ptr(int64(s.MbufInuse)),
+		ErrorBytesQ: queueStats(s.ErrorsQ),
+		InBytes:     ptr(int64(s.InBytes)),
+		InBytesQ:    queueStats(s.InBytesQ),
+		InErrors:    ptr(int64(s.InErrors)),
+		InMissed:    ptr(int64(s.InMissed)),
+		InPkts:      ptr(int64(s.InPkts)),
+		InPktsQ:     queueStats(s.InPktsQ),
+		OutBytes:    ptr(int64(s.OutBytes)),
+		OutBytesQ:   queueStats(s.OutBytesQ),
+		OutErrors:   ptr(int64(s.OutErrors)),
+		OutPkts:     ptr(int64(s.OutPkts)),
+		OutPktsQ:    queueStats(s.OutPktsQ),
+		RxNoMbuf:    ptr(int64(s.RxNoMbuf)),
+	}
+}
+
+func queueStats(q [netifMaxQueues]uint64) []int64 {
+	ret := make([]int64, len(q))
+	for i, v := range q {
+		ret[i] = int64(v)
+	}
+	return ret
+}
+
+// sockAddr formats a union inet_addr of the given address family.
+func sockAddr(af int32, addr [16]byte) string {
+	if af == syscall.AF_INET6 {
+		return net.IP(addr[:]).String()
+	}
+	return net.IP(addr[:net.IPv4len]).String()
+}
+
+// cString returns the NUL terminated string at the start of b.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,131 @@
+package lb
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDpvs replays sockopt replies on a unix socket, keyed by sockopt id.
+// The replies of testdata/*.bin are written by testdata/dpvs_sockopt.c with
+// the DPVS C structures, on a little-endian host.
+type fakeDpvs struct {
+	ln      net.Listener
+	replies map[uint32][]byte
+}
+
+func newFakeDpvs(t *testing.T, replies map[uint32]string) *fakeDpvs {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the sockopt replies were written on a little-endian host")
+	}
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "dpvs.ipc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeDpvs{
+		ln:      ln,
+		replies: make(map[uint32][]byte),
+	}
+	for id, file := range replies {
+		if f.replies[id], err = os.ReadFile(filepath.Join("testdata", file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go f.serve()
+	return f
+}
+
+func (f *fakeDpvs) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		var msg sockMsg
+		if err := binary.Read(conn, binary.NativeEndian, &msg); err == nil {
+			io.CopyN(io.Discard, conn, int64(msg.Len))
+			conn.Write(f.replies[msg.ID])
+		}
+		conn.Close()
+	}
+}
+
+func TestSocketListVirtualServices(t *testing.T) {
+	f := newFakeDpvs(t, map[uint32]string{
+		soIDGetInfo:     "dpvs_so_get_info.bin",
+		soIDGetServices: "dpvs_so_get_services.bin",
+		soIDGetDests:    "dpvs_so_get_dests.bin",
+	})
+
+	vss, err := NewDpvsSocketComm(f.ln.Addr().String()).ListVirtualServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vss.Items) != 2 {
+		t.Fatalf("got %d services, want 2", len(vss.Items))
+	}
+	vs := vss.Items[0]
+	if *vs.Addr != "2001:db8::1" || *vs.Port != 443 || *vs.Proto != 6 || *vs.AF != 10 ||
+		*vs.SchedName != Wrr || *vs.ConnTimeout != 90 || *vs.Netmask != 128 || *vs.Bps != 1000000 ||
+		*vs.LimitProportion != 80 || vs.Match != nil {
+		t.Errorf("unexpected service %+v", vs)
+	}
+	if s := vs.Stats; *s.Conns != 10 || *s.InPkts != 20 || *s.InBytes != 1000 || *s.OutPkts != 30 ||
+		*s.OutBytes != 2000 || *s.CPS != 3 {
+		t.Errorf("unexpected service stats %+v", s)
+	}
+	if vs.RSs == nil || len(vs.RSs.Items) != 1 {
+		t.Fatalf("unexpected real servers %+v", vs.RSs)
+	}
+	rs := vs.RSs.Items[0]
+	if *rs.Spec.IP != "192.168.1.10" || *rs.Spec.Port != 8443 || *rs.Spec.Weight != 100 ||
+		*rs.Spec.Mode != Fnat || !*rs.Spec.Inhibited || *rs.Spec.Overloaded ||
+		*rs.Stats.Conns != 4 || *rs.Stats.InBytes != 400 {
+		t.Errorf("unexpected real server %+v %+v", rs.Spec, rs.Stats)
+	}
+
+	snat := vss.Items[1]
+	if *snat.Proto != 17 || *snat.AF != 2 || *snat.SchedName != "rr" || *snat.Stats.Conns != 7 || snat.Match == nil {
+		t.Fatalf("unexpected SNAT service %+v", snat)
+	}
+	if m := snat.Match; *m.Src.Start != "10.0.0.1" || *m.Src.End != "10.0.0.9" || m.Dest != nil ||
+		m.InIfName != nil || *m.OutIfName != "dpdk1" {
+		t.Errorf("unexpected SNAT match %+v", m)
+	}
+}
+
+func TestSocketListNicStats(t *testing.T) {
+	f := newFakeDpvs(t, map[uint32]string{
+		soIDNetifPortList:  "dpvs_so_netif_port_list.bin",
+		soIDNetifPortStats: "dpvs_so_netif_port_stats.bin",
+	})
+
+	stats, err := NewDpvsSocketComm(f.ln.Addr().String()).ListNicStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d NICs, want 2", len(stats))
+	}
+	nic := stats[1]
+	if *nic.Name != "dpdk1" || *nic.BufAvail != 100 || *nic.BufInuse != 28 || *nic.InPkts != 5 ||
+		*nic.OutPkts != 6 || *nic.InBytes != 500 || *nic.OutBytes != 600 || *nic.InMissed != 2 ||
+		*nic.InErrors != 1 || *nic.RxNoMbuf != 3 || nic.InPktsQ[1] != 5 || nic.ErrorBytesQ[15] != 1 {
+		t.Errorf("unexpected NIC stats %+v", nic)
+	}
+}
+
+func TestSocketError(t *testing.T) {
+	f := newFakeDpvs(t, map[uint32]string{
+		soIDGetInfo: "dpvs_so_get_info_error.bin",
+	})
+	_, err := NewDpvsSocketComm(f.ln.Addr().String()).ListVirtualServices()
+	if err == nil || !strings.Contains(err.Error(), "not support (-16)") {
+		t.Errorf("got %v, want the DPVS error", err)
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ * dpvs_sockopt.c writes the DPVS sockopt replies replayed by
+ * dpvs_socket_test.go, each a struct dpvs_sock_msg_reply followed by its
+ * payload, as DPVS sends them on its control socket.
+ *
+ * The structures are those of DPVS's include/conf headers (sockopts.h,
+ * service.h, dest.h, netif.h, inet.h), laid out by the C compiler, so that the
+ * test checks the Go layouts of dpvs_socket.go against the C ones rather than
+ * against themselves. The files were generated on x86-64 Linux:
+ *
+ *	cc -o /tmp/dpvs_sockopt dpvs_sockopt.c && (cd lb/testdata && /tmp/dpvs_sockopt)
+ */
+#include <arpa/inet.h>
+#include <errno.h>
+#include <netinet/in.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/socket.h>
+
+#define SOCKOPT_VERSION     0x010000
+#define SOCKOPT_GET         0
+#define SOCKOPT_ERRSTR_LEN  64
+#define IFNAMSIZ            16
+#define DP_VS_SCHEDNAME_MAXLEN 16
+#define NETIF_MAX_QUEUES    16
+
+#define DPVS_SO_GET_INFO    201
+#define DPVS_SO_GET_SERVICES 202
+#define DPVS_SO_GET_DESTS   204
+#define SOCKOPT_NETIF_GET_PORT_LIST  503
+#define SOCKOPT_NETIF_GET_PORT_STATS 505
+
+#define DPVS_FWD_MODE_FNAT  5
+#define DPVS_FWD_MODE_SNAT  6
+#define DPVS_DEST_F_OVERLOAD  0x0002
+#define DPVS_DEST_F_INHIBITED 0x0004
+
+typedef uint32_t sockoptid_t;
+typedef uint8_t lcoreid_t;
+typedef uint16_t portid_t;
+
+union inet_addr {
+	struct in_addr in;
+	struct in6_addr in6;
+};
+
+struct inet_addr_range {
+	union inet_addr min_addr;
+	union inet_addr max_addr;
+	uint16_t min_port;
+	uint16_t max_port;
+};
+
+struct dpvs_sock_msg_reply {
+	uint32_t version;
+	sockoptid_t id;
+	uint32_t type;
+	int errcode;
+	char errstr[SOCKOPT_ERRSTR_LEN];
+	size_t len;
+	char data[0];
+};
+
+struct dp_vs_getinfo {
+	unsigned int version;
+	unsigned int size;
+	unsigned int num_services;
+};
+
+struct dp_vs_stats {
+	uint64_t conns;
+	uint64_t inpkts;
+	uint64_t inbytes;
+	uint64_t outpkts;
+	uint64_t outbytes;
+	uint32_t cps;
+	uint32_t inpps;
+	uint32_t inbps;
+	uint32_t outpps;
+	uint32_t outbps;
+};
+
+struct dp_vs_service_entry {
+	int af;
+	uint16_t proto;
+	union inet_addr addr;
+	uint16_t port;
+	uint32_t fwmark;
+	char sched_name[DP_VS_SCHEDNAME_MAXLEN];
+	unsigned flags;
+	unsigned timeout;
+	unsigned conn_timeout;
+	uint32_t netmask;
+	unsigned bps;
+	unsigned limit_proportion;
+	unsigned int num_dests;
+	unsigned int num_laddrs;
+	lcoreid_t cid;
+	lcoreid_t index;
+	struct dp_vs_stats stats;
+	struct inet_addr_range srange;
+	struct inet_addr_range drange;
+	char iifname[IFNAMSIZ];
+	char oifname[IFNAMSIZ];
+};
+
+struct dp_vs_get_services {
+	lcoreid_t cid;
+	lcoreid_t index;
+	unsigned int num_services;
+	struct dp_vs_service_entry entrytable[0];
+};
+
+struct dp_vs_dest_entry {
+	int af;
+	union inet_addr addr;
+	uint16_t port;
+	unsigned conn_flags;
+	unsigned flags;
+	int weight;
+	uint32_t max_conn;
+	uint32_t min_conn;
+	uint32_t actconns;
+	uint32_t inactconns;
+	uint32_t persistconns;
+	struct dp_vs_stats stats;
+};
+
+struct dp_vs_get_dests {
+	int af;
+	uint16_t proto;
+	union inet_addr addr;
+	uint16_t port;
+	uint32_t fwmark;
+	unsigned int num_dests;
+	lcoreid_t cid;
+	lcoreid_t index;
+	struct dp_vs_dest_entry entrytable[0];
+};
+
+struct port_id_name {
+	portid_t id;
+	char name[IFNAMSIZ];
+};
+
+struct netif_nic_list_get {
+	uint16_t nic_num;
+	portid_t phy_pid_base;
+	portid_t phy_pid_end;
+	portid_t bond_pid_base;
+	portid_t bond_pid_end;
+	struct port_id_name idname[0];
+};
+
+struct netif_nic_stats_get {
+	uint32_t mbuf_avail;
+	uint32_t mbuf_inuse;
+	uint64_t ipackets;
+	uint64_t opackets;
+	uint64_t ibytes;
+	uint64_t obytes;
+	uint64_t imissed;
+	uint64_t ierrors;
+	uint64_t oerrors;
+	uint64_t rx_nombuf;
+	uint64_t q_ipackets[NETIF_MAX_QUEUES];
+	uint64_t q_opackets[NETIF_MAX_QUEUES];
+	uint64_t q_ibytes[NETIF_MAX_QUEUES];
+	uint64_t q_obytes[NETIF_MAX_QUEUES];
+	uint64_t q_errors[NETIF_MAX_QUEUES];
+};
+
+static void reply(const char *file, sockoptid_t id, int errcode, const char *errstr,
+		  const void *data, size_t len)
+{
+	struct dpvs_sock_msg_reply hdr;
+	FILE *f;
+
+	memset(&hdr, 0, sizeof(hdr));
+	hdr.version = SOCKOPT_VERSION;
+	hdr.id = id;
+	hdr.type = SOCKOPT_GET;
+	hdr.errcode = errcode;
+	if (errstr)
+		strncpy(hdr.errstr, errstr, sizeof(hdr.errstr) - 1);
+	hdr.len = len;
+
+	if (!(f = fopen(file, "wb")) || fwrite(&hdr, sizeof(hdr), 1, f) != 1 ||
+	    (len && fwrite(data, len, 1, f) != 1) || fclose(f)) {
+		perror(file);
+		exit(1);
+	}
+}
+
+int main(void)
+{
+	struct dp_vs_getinfo info = { .version = 0x010900, .size = 0, .num_services = 2 };
+	struct dp_vs_service_entry *svc;
+	struct dp_vs_get_services *svcs;
+	struct dp_vs_get_dests *dests;
+	struct netif_nic_list_get *ports;
+	struct netif_nic_stats_get nic;
+	size_t len;
+
+	reply("dpvs_so_get_info.bin", DPVS_SO_GET_INFO, 0, NULL, &info, sizeof(info));
+	/* EDPVS_NOTSUPPORT, as replied by a dataplane without the sockopt. */
+	reply("dpvs_so_get_info_error.bin", DPVS_SO_GET_INFO, -16, "not support", NULL, 0);
+
+	len = sizeof(*svcs) + 2 * sizeof(*svc);
+	svcs = calloc(1, len);
+	svcs->num_services = 2;
+
+	/* An FNAT service, [2001:db8::1]:443 over TCP. */
+	svc = &svcs->entrytable[0];
+	svc->af = AF_INET6;
+	svc->proto = IPPROTO_TCP;
+	inet_pton(AF_INET6, "2001:db8::1", &svc->addr.in6);
+	svc->port = htons(443);
+	strcpy(svc->sched_name, "wrr");
+	svc->conn_timeout = 90;
+	svc->netmask = 128;
+	svc->bps = 1000000;
+	svc->limit_proportion = 80;
+	svc->num_dests = 1;
+	svc->num_laddrs = 2;
+	svc->stats.conns = 10;
+	svc->stats.inpkts = 20;
+	svc->stats.inbytes = 1000;
+	svc->stats.outpkts = 30;
+	svc->stats.outbytes = 2000;
+	svc->stats.cps = 3;
+
+	/* A SNAT match service, from 10.0.0.1-10.0.0.9 out of dpdk1. */
+	svc = &svcs->entrytable[1];
+	svc->af = AF_INET;
+	svc->proto = IPPROTO_UDP;
+	strcpy(svc->sched_name, "rr");
+	svc->num_dests = 1;
+	inet_pton(AF_INET, "10.0.0.1", &svc->srange.min_addr.in);
+	inet_pton(AF_INET, "10.0.0.9", &svc->srange.max_addr.in);
+	strcpy(svc->oifname, "dpdk1");
+	svc->stats.conns = 7;
+
+	reply("dpvs_so_get_services.bin", DPVS_SO_GET_SERVICES, 0, NULL, svcs, len);
+
+	len = sizeof(*dests) + sizeof(dests->entrytable[0]);
+	dests = calloc(1, len);
+	dests->af = AF_INET6;
+	dests->proto = IPPROTO_TCP;
+	inet_pton(AF_INET6, "2001:db8::1", &dests->addr.in6);
+	dests->port = htons(443);
+	dests->num_dests = 1;
+	dests->entrytable[0].af = AF_INET;
+	inet_pton(AF_INET, "192.168.1.10", &dests->entrytable[0].addr.in);
+	dests->entrytable[0].port = htons(8443);
+	dests->entrytable[0].conn_flags = DPVS_FWD_MODE_FNAT;
+	dests->entrytable[0].flags = DPVS_DEST_F_INHIBITED;
+	dests->entrytable[0].weight = 100;
+	dests->entrytable[0].actconns = 3;
+	dests->entrytable[0].stats.conns = 4;
+	dests->entrytable[0].stats.inbytes = 400;
+
+	reply("dpvs_so_get_dests.bin", DPVS_SO_GET_DESTS, 0, NULL, dests, len);
+
+	len = sizeof(*ports) + 2 * sizeof(ports->idname[0]);
+	ports = calloc(1, len);
+	ports->nic_num = 2;
+	ports->phy_pid_end = 2;
+	ports->bond_pid_base = 2;
+	ports->bond_pid_end = 2;
+	ports->idname[0].id = 0;
+	strcpy(ports->idname[0].name, "dpdk0");
+	ports->idname[1].id = 1;
+	strcpy(ports->idname[1].name, "dpdk1");
+
+	reply("dpvs_so_netif_port_list.bin", SOCKOPT_NETIF_GET_PORT_LIST, 0, NULL, ports, len);
+
+	memset(&nic, 0, sizeof(nic));
+	nic.mbuf_avail = 100;
+	nic.mbuf_inuse = 28;
+	nic.ipackets = 5;
+	nic.opackets = 6;
+	nic.ibytes = 500;
+	nic.obytes = 600;
+	nic.imissed = 2;
+	nic.ierrors = 1;
+	nic.rx_nombuf = 3;
+	nic.q_ipackets[1] = 5;
+	nic.q_errors[15] = 1;
+
+	reply("dpvs_so_netif_port_stats.bin", SOCKOPT_NETIF_GET_PORT_STATS, 0, NULL, &nic, sizeof(nic));
+	return 0;
+}
@@ -32,6 +32,51 @@ type VirtualService struct {
 	RSs      []RealServer
 }
 
+// Backend is a source of the dataplane state exported by the collectors.
+type Backend interface {
+	ListVirtualServices() (*VsResponse, error)
+	ListNicStats() ([]*NICDeviceStats, error)
+	ListNicName() ([]string, error)
+}
+
+// ConnEntry is an entry of the DPVS connection table.
+type ConnEntry struct {
+	Proto   utils.IPProto
+	State   string
+	Client  ConnAddr
+	Virtual ConnAddr
+	Local   ConnAddr
+	Dest    ConnAddr
+}
+
+// ConnAddr is one side of a connection.
+type ConnAddr struct {
+	IP   string
+	Port int64
+}
+
+// ConnLister lists the DPVS connection table, stopping after limit entries
+// when limit is positive; truncated tells whether entries were left out.
+type ConnLister interface {
+	ListConns(limit int) (conns []ConnEntry, truncated bool, err error)
+}
+
+// AccessListEntry is an address in the blacklist or whitelist of a virtual
+// service.
+type AccessListEntry struct {
+	VIP   string
+	Port  int64
+	Proto utils.IPProto
+	Addr  string
+}
+
+// AccessLister lists the per-service blacklists and whitelists, which DPVS
+// keeps apart from the allow and deny lists.
+type AccessLister interface {
+	ListBlacklist() ([]AccessListEntry, error)
+	ListWhitelist() ([]AccessListEntry, error)
+}
+
 type Comm interface {
 	ListVirtualServices() ([]VirtualService, error)
 	UpdateByChecker(targets []VirtualService) error
@@ -120,6 +165,81 @@ const (
 	Up   Status = "UP"
 )
 
+// InetAddrList
+type InetAddrList struct {
+	Items []InetAddrSpec `json:"Items,omitempty"`
+}
+
+// InetAddrSpec
+type InetAddrSpec struct {
+	Addr      *string `json:"addr,omitempty"`
+	Broadcast *string `json:"broadcast,omitempty"`
+	Scope     *string `json:"scope,omitempty"`
+}
+
+// RouteList
+type RouteList struct {
+	Items []RouteSpec `json:"Items,omitempty"`
+}
+
+// RouteSpec
+type RouteSpec struct {
+	Device    *string `json:"device,omitempty"`
+	Dst       *string `json:"dst,omitempty"`
+	Gateway   *string `json:"gateway,omitempty"`
+	Metric    *int64  `json:"metric,omitempty"`
+	MTU       *int64  `json:"mtu,omitempty"`
+	PrefixSrc *string `json:"prefixSrc,omitempty"`
+	Scope     *string `json:"scope,omitempty"`
+	Src       *string `json:"src,omitempty"`
+}
+
+// VlanList
+type VlanList struct {
+	Items []VlanSpec `json:"Items,omitempty"`
+}
+
+// VlanSpec
+type VlanSpec struct {
+	Device *string `json:"device,omitempty"`
+	ID     *string `json:"id,omitempty"`
+	Name   *string `json:"name,omitempty"`
+}
+
+// IpsetInfoArray
+type IpsetInfoArray struct {
+	Count *int64      `json:"Count,omitempty"`
+	Infos []IpsetInfo `json:"Infos,omitempty"`
+}
+
+// IpsetInfo
+type IpsetInfo struct {
+	Entries []IpsetMember `json:"Entries,omitempty"`
+	Name    *string       `json:"Name,omitempty"`
+	Type    *IpsetType    `json:"Type,omitempty"`
+}
+
+// IpsetMember
+type IpsetMember struct {
+	Comment *string `json:"Comment,omitempty"`
+	Entry   *string `json:"Entry,omitempty"`
+}
+
+// IpsetType
+type IpsetType string
+
+const (
+	BitmapIP      IpsetType = "bitmap:ip"
+	BitmapIPMac   IpsetType = "bitmap:ip,mac"
+	BitmapPort    IpsetType = "bitmap:port"
+	HashIP        IpsetType = "hash:ip"
+	HashIPPort    IpsetType = "hash:ip,port"
+	HashIPPortIP  IpsetType = "hash:ip,port,ip"
+	HashIPPortNet IpsetType = "hash:ip,port,net"
+	HashNet       IpsetType = "hash:net"
+	HashNetPort   IpsetType = "hash:net,port"
+)
+
 // Request
 //
 // VirtualServerList
@@ -204,6 +324,30 @@ type ServerStats struct {
 	OutPps   *int64 `json:"OutPps,omitempty"`
 }
 
+// LocalAddressExpandList
+type LocalAddressExpandList struct {
+	Items []LocalAddressSpecExpand `json:"Items,omitempty"`
+}
+
+// LocalAddressSpecExpand
+type LocalAddressSpecExpand struct {
+	Addr         *string `json:"addr,omitempty"`
+	AF           *int64  `json:"af,omitempty"`
+	Conns        *int64  `json:"conns,omitempty"`
+	Device       *string `json:"device,omitempty"`
+	PortConflict *int64  `json:"portConflict,omitempty"`
+}
+
+// AclAddrList
+type AclAddrList struct {
+	Items []CertAuthSpec `json:"Items,omitempty"`
+}
+
+// CertAuthSpec
+type CertAuthSpec struct {
+	Addr *string `json:"addr,omitempty"`
+}
+
 // DestCheckSpec
 type DestCheckSpec string
 
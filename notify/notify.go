@@ -0,0 +1,233 @@
+// Package notify sends notifications when real servers change state.
+package notify
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"dpvs_exporter/collector"
+	"dpvs_exporter/lb"
+)
+
+// States of a real server.
+const (
+	StateInhibited  = "inhibited"
+	StateOverloaded = "overloaded"
+	StateOK         = "ok"
+)
+
+const (
+	DefaultInterval = 10 * time.Second
+	DefaultDebounce = 30 * time.Second
+
+	// queueSize bounds the deliveries pending per sender.
+	queueSize = 256
+)
+
+// Event is a real server entering a state, "ok" when it recovers.
+type Event struct {
+	Time     time.Time `json:"time"`
+	VS       string    `json:"vs"`
+	RS       string    `json:"rs"`
+	State    string    `json:"state"`
+	Previous string    `json:"previous"`
+}
+
+func (ev Event) String() string {
+	if ev.State == StateOK {
+		return fmt.Sprintf("Real server %s of %s recovered from %s", ev.RS, ev.VS, ev.Previous)
+	}
+	return fmt.Sprintf("Real server %s of %s is %s", ev.RS, ev.VS, ev.State)
+}
+
+// Sender delivers events.
+type Sender interface {
+	Send(ev Event) error
+}
+
+// Refresher is implemented by senders whose receivers forget the states they
+// were notified of unless reminded, e.g. Alertmanager resolving alerts after
+// its resolve_timeout. Refresh is called on every poll with the real servers
+// in another state than ok, each as the event of its entering that state.
+type Refresher interface {
+	Refresh(active []Event) error
+}
+
+func serverState(spec *lb.RealServerSpecTiny) string {
+	switch {
+	case spec.Inhibited != nil && *spec.Inhibited:
+		return StateInhibited
+	case spec.Overloaded != nil && *spec.Overloaded:
+		return StateOverloaded
+	}
+	return StateOK
+}
+
+// trackedServer tracks the state of a real server: notified is the last one
+// notified, entered at notifiedAt, pending a different one seen since.
+type trackedServer struct {
+	notified   string
+	notifiedAt time.Time
+	pending    string
+	since      time.Time
+}
+
+// Watcher polls the real servers and notifies the senders once one has been
+// in a new state for the debounce period, so that a state reverting quickly
+// is not notified. The states found on the first poll are not notified. Each
+// sender is delivered its events one at a time, in order, so that a retried
+// event never lands after the one that followed it.
+type Watcher struct {
+	comm     lb.Backend
+	senders  []Sender
+	interval time.Duration
+	debounce time.Duration
+
+	servers map[[2]string]*trackedServer
+	polled  bool
+	queues  []chan func() error
+}
+
+// NewWatcher returns a Watcher polling every interval, DefaultInterval if
+// zero.
+func NewWatcher(comm lb.Backend, senders []Sender, interval, debounce time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Watcher{
+		comm:     comm,
+		senders:  senders,
+		interval: interval,
+		debounce: debounce,
+		servers:  make(map[[2]string]*trackedServer),
+	}
+}
+
+// Run polls until the program exits.
+func (w *Watcher) Run() {
+	w.start()
+	for range time.Tick(w.interval) {
+		services, err := w.comm.ListVirtualServices()
+		if err != nil {
+			log.Printf("Failed to poll real server states: %v", err)
+			continue
+		}
+		w.dispatch(services, time.Now())
+	}
+}
+
+// start runs a delivery queue per sender.
+func (w *Watcher) start() {
+	w.queues = make([]chan func() error, len(w.senders))
+	for i := range w.senders {
+		w.queues[i] = make(chan func() error, queueSize)
+		go func(queue <-chan func() error) {
+			for deliver := range queue {
+				if err := deliver(); err != nil {
+					log.Printf("Failed to notify: %v", err)
+				}
+			}
+		}(w.queues[i])
+	}
+}
+
+// dispatch polls services and queues the resulting events, then the active
+// states to refresh, for every sender.
+func (w *Watcher) dispatch(services *lb.VsResponse, now time.Time) {
+	events := w.poll(services, now)
+	active := w.active()
+	for i, s := range w.senders {
+		for _, ev := range events {
+			w.enqueue(i, func() error {
+				if err := s.Send(ev); err != nil {
+					return fmt.Errorf("%q: %v", ev, err)
+				}
+				return nil
+			})
+		}
+		if r, ok := s.(Refresher); ok && len(active) > 0 {
+			w.enqueue(i, func() error { return r.Refresh(active) })
+		}
+	}
+}
+
+// enqueue queues a delivery to the sender i, dropping it if the sender is too
+// far behind.
+func (w *Watcher) enqueue(i int, deliver func() error) {
+	select {
+	case w.queues[i] <- deliver:
+	default:
+		log.Printf("Notification queue %d full, dropping a notification", i)
+	}
+}
+
+// active returns the real servers in another state than ok, each as the event
+// of its entering that state.
+func (w *Watcher) active() []Event {
+	var events []Event
+	for key, s := range w.servers {
+		if s.notified != StateOK {
+			events = append(events, Event{Time: s.notifiedAt, VS: key[0], RS: key[1], State: s.notified})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].VS != events[j].VS {
+			return events[i].VS < events[j].VS
+		}
+		return events[i].RS < events[j].RS
+	})
+	return events
+}
+
+// poll updates the tracked states from services and returns the events to
+// notify.
+func (w *Watcher) poll(services *lb.VsResponse, now time.Time) []Event {
+	if services == nil {
+		return nil
+	}
+	var events []Event
+	seen := make(map[[2]string]bool)
+	for _, vss := range services.Items {
+		if vss.RSs == nil {
+			continue
+		}
+		vs := collector.GetServiceIdentifier(&vss)
+		for _, rs := range vss.RSs.Items {
+			if rs.Spec == nil {
+				continue
+			}
+			key := [2]string{vs, collector.GetServerIdentifier(rs.Spec.IP, rs.Spec.Port, vss.Proto)}
+			seen[key] = true
+			state := serverState(rs.Spec)
+			s, exists := w.servers[key]
+			if !exists {
+				// Servers added after the first poll start out ok, so that
+				// one added in a bad state is notified.
+				s = &trackedServer{notified: StateOK, notifiedAt: now}
+				if !w.polled {
+					s.notified = state
+				}
+				w.servers[key] = s
+			}
+			switch {
+			case state == s.notified:
+				s.pending = ""
+			case state != s.pending:
+				s.pending, s.since = state, now
+			}
+			if s.pending != "" && now.Sub(s.since) >= w.debounce {
+				events = append(events, Event{Time: now, VS: key[0], RS: key[1], State: state, Previous: s.notified})
+				s.notified, s.notifiedAt, s.pending = state, now, ""
+			}
+		}
+	}
+	for key := range w.servers {
+		if !seen[key] {
+			delete(w.servers, key)
+		}
+	}
+	w.polled = true
+	return events
+}
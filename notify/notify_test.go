@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dpvs_exporter/lb"
+)
+
+func services(inhibited, overloaded bool) *lb.VsResponse {
+	addr, port, proto := "10.0.0.1", int64(80), int64(6)
+	rip := "10.1.0.1"
+	return &lb.VsResponse{Items: []lb.VirtualServerSpecExpand{{
+		Addr:  &addr,
+		Port:  &port,
+		Proto: &proto,
+		RSs: &lb.RealServerExpandList{Items: []lb.RealServerSpecExpand{{
+			Spec: &lb.RealServerSpecTiny{IP: &rip, Port: &port, Inhibited: &inhibited, Overloaded: &overloaded},
+		}}},
+	}}}
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	w := NewWatcher(nil, nil, 0, 20*time.Second)
+	t0 := time.Unix(1000, 0)
+	steps := []struct {
+		after      time.Duration
+		inhibited  bool
+		overloaded bool
+		want       string
+	}{
+		{0, false, false, ""},
+		{10 * time.Second, true, false, ""},
+		{20 * time.Second, false, false, ""}, // reverted within the debounce period
+		{30 * time.Second, true, false, ""},
+		{50 * time.Second, true, false, StateInhibited},
+		{60 * time.Second, false, true, ""},
+		{80 * time.Second, false, true, StateOverloaded},
+		{90 * time.Second, false, false, ""},
+		{110 * time.Second, false, false, StateOK},
+	}
+	for _, s := range steps {
+		events := w.poll(services(s.inhibited, s.overloaded), t0.Add(s.after))
+		var got string
+		if len(events) == 1 {
+			got = events[0].State
+		} else if len(events) > 1 {
+			t.Fatalf("at %v: got %d events", s.after, len(events))
+		}
+		if got != s.want {
+			t.Errorf("at %v: got event %q, want %q", s.after, got, s.want)
+		}
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var requests []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		if len(requests) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	ev := Event{VS: "10.0.0.1:80:TCP", RS: "10.1.0.1:80:TCP", State: StateInhibited, Previous: StateOK}
+	hook := NewWebhook(srv.URL, FormatSlack, 2)
+	hook.backoff = time.Millisecond
+	if err := hook.Send(ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 || requests[2]["text"] != ev.String() {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	hook.Retries = 0
+	requests = nil
+	if err := hook.Send(ev); err == nil {
+		t.Error("expected an error without retries")
+	}
+}
+
+func TestAlertmanagerPayload(t *testing.T) {
+	var alerts []alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&alerts)
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1000, 0).UTC()
+	ev := Event{Time: now, VS: "10.0.0.1:80:TCP", RS: "10.1.0.1:80:TCP", State: StateOverloaded, Previous: StateInhibited}
+	if err := NewWebhook(srv.URL, FormatAlertmanager, 0).Send(ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts, want 2", len(alerts))
+	}
+	if a := alerts[0]; a.Labels["alertname"] != "DpvsRealServerInhibited" || a.EndsAt == nil || !a.EndsAt.Equal(now) || a.StartsAt != nil {
+		t.Errorf("unexpected resolved alert %+v", a)
+	}
+	if a := alerts[1]; a.Labels["alertname"] != "DpvsRealServerOverloaded" || a.Labels["rs"] != ev.RS || a.StartsAt == nil || !a.StartsAt.Equal(now) {
+		t.Errorf("unexpected firing alert %+v", a)
+	}
+}
+
+func TestWatcherDeliversInOrder(t *testing.T) {
+	posted := make(chan string, 8)
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		json.NewDecoder(r.Body).Decode(&ev)
+		// The first event only gets through on a retry.
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		posted <- ev.State
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(srv.URL, FormatGeneric, 1)
+	hook.backoff = 50 * time.Millisecond
+	w := NewWatcher(nil, []Sender{hook}, 0, 0)
+	w.start()
+	t0 := time.Unix(1000, 0)
+	w.dispatch(services(false, false), t0)
+	w.dispatch(services(true, false), t0.Add(time.Second))
+	w.dispatch(services(false, false), t0.Add(2*time.Second))
+
+	for _, want := range []string{StateInhibited, StateOK} {
+		select {
+		case got := <-posted:
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestWatcherRefreshesAlerts(t *testing.T) {
+	posted := make(chan []alert, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alerts []alert
+		json.NewDecoder(r.Body).Decode(&alerts)
+		posted <- alerts
+	}))
+	defer srv.Close()
+
+	w := NewWatcher(nil, []Sender{NewWebhook(srv.URL, FormatAlertmanager, 0)}, 0, 0)
+	w.start()
+	t0 := time.Unix(1000, 0).UTC()
+	w.dispatch(services(false, false), t0)
+	w.dispatch(services(true, false), t0.Add(time.Second))
+	w.dispatch(services(true, false), t0.Add(2*time.Second))
+	w.dispatch(services(false, false), t0.Add(3*time.Second))
+	w.dispatch(services(false, false), t0.Add(4*time.Second))
+
+	next := func() []alert {
+		select {
+		case alerts := <-posted:
+			return alerts
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for alerts")
+		}
+		return nil
+	}
+	// The transition, then a refresh on each poll while inhibited.
+	for i := 0; i < 3; i++ {
+		alerts := next()
+		if len(alerts) != 1 || alerts[0].Labels["alertname"] != "DpvsRealServerInhibited" ||
+			!alerts[0].StartsAt.Equal(t0.Add(time.Second)) || alerts[0].EndsAt != nil {
+			t.Errorf("post %d: unexpected alerts %+v", i, alerts)
+		}
+	}
+	if alerts := next(); len(alerts) != 1 || alerts[0].EndsAt == nil {
+		t.Errorf("unexpected resolved alerts %+v", alerts)
+	}
+	select {
+	case alerts := <-posted:
+		t.Errorf("refreshed alerts after recovery: %+v", alerts)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Payload formats of a Webhook.
+const (
+	FormatGeneric      = "generic"
+	FormatSlack        = "slack"
+	FormatAlertmanager = "alertmanager"
+)
+
+const (
+	DefaultRetries = 3
+
+	webhookTimeout = 10 * time.Second
+	retryBackoff   = time.Second
+)
+
+// Webhook posts events to a URL as JSON, in one of the payload formats.
+type Webhook struct {
+	URL     string
+	Format  string
+	Retries int // attempts after the first failed one
+
+	client  *http.Client
+	backoff time.Duration
+}
+
+func NewWebhook(url, format string, retries int) *Webhook {
+	return &Webhook{
+		URL:     url,
+		Format:  format,
+		Retries: retries,
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: retryBackoff,
+	}
+}
+
+// Send posts ev, retrying with a linearly growing backoff until the webhook
+// answers with a 2xx status or the retries are exhausted.
+func (w *Webhook) Send(ev Event) error {
+	body, err := w.payload(ev)
+	if err != nil {
+		return err
+	}
+	return w.deliver(body)
+}
+
+// Refresh re-posts the firing alerts of the active states to Alertmanager,
+// which resolves an alert without an end time once it hasn't been posted for
+// its resolve_timeout. The other formats only notify changes.
+func (w *Webhook) Refresh(active []Event) error {
+	if w.Format != FormatAlertmanager {
+		return nil
+	}
+	var alerts []alert
+	for _, ev := range active {
+		alerts = append(alerts, alertmanagerAlerts(ev)...)
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	return w.deliver(body)
+}
+
+func (w *Webhook) deliver(body []byte) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = w.post(body); err == nil || attempt == w.Retries {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * w.backoff)
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	resp, err := w.client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: status %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+func (w *Webhook) payload(ev Event) ([]byte, error) {
+	switch w.Format {
+	case FormatGeneric, "":
+		return json.Marshal(ev)
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": ev.String()})
+	case FormatAlertmanager:
+		return json.Marshal(alertmanagerAlerts(ev))
+	}
+	return nil, fmt.Errorf("unknown webhook format %q", w.Format)
+}
+
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    *time.Time        `json:"startsAt,omitempty"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+}
+
+// alertNames are the alerts raised while a real server is in a state.
+var alertNames = map[string]string{
+	StateInhibited:  "DpvsRealServerInhibited",
+	StateOverloaded: "DpvsRealServerOverloaded",
+}
+
+// alertmanagerAlerts returns the alerts to post to the Alertmanager API for
+// ev: the alert of the state entered fires, the one of the state left is
+// resolved.
+func alertmanagerAlerts(ev Event) []alert {
+	var alerts []alert
+	for _, s := range []struct {
+		state  string
+		firing bool
+	}{
+		{ev.Previous, false},
+		{ev.State, true},
+	} {
+		name, ok := alertNames[s.state]
+		if !ok {
+			continue
+		}
+		a := alert{
+			Labels:      map[string]string{"alertname": name, "vs": ev.VS, "rs": ev.RS},
+			Annotations: map[string]string{"summary": ev.String()},
+		}
+		at := ev.Time
+		if s.firing {
+			a.StartsAt = &at
+		} else {
+			a.EndsAt = &at
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
@@ -17,6 +17,7 @@ package utils
 import (
 	"fmt"
 	"net"
+	"strings"
 	"syscall"
 )
 
@@ -92,6 +93,7 @@ const (
 	IPProtoICMPv6 IPProto = syscall.IPPROTO_ICMPV6
 	IPProtoTCP    IPProto = syscall.IPPROTO_TCP
 	IPProtoUDP    IPProto = syscall.IPPROTO_UDP
+	IPProtoSCTP   IPProto = syscall.IPPROTO_SCTP
 )
 
 // String returns the name for the given protocol value.
@@ -105,20 +107,25 @@ func (proto IPProto) String() string {
 		return "TCP"
 	case IPProtoUDP:
 		return "UDP"
+	case IPProtoSCTP:
+		return "SCTP"
 	}
 	return fmt.Sprintf("IP(%d)", proto)
 }
 
+// IPProtoFromStr returns the protocol for the given name, ignoring case.
 func IPProtoFromStr(str string) IPProto {
-	switch str {
+	switch strings.ToUpper(str) {
 	case "TCP":
 		return IPProtoTCP
 	case "UDP":
 		return IPProtoUDP
 	case "ICMP":
 		return IPProtoICMP
-	case "ICMPv6":
+	case "ICMPV6":
 		return IPProtoICMPv6
+	case "SCTP":
+		return IPProtoSCTP
 	}
 	return 0
 }